@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/vizshrc/m3u8-downloader/m3u8dl"
+)
+
+// One line of a -batch file
+type batchEntry struct {
+	line   int
+	url    string
+	output string // "" lets the library pick a name
+}
+
+// Read a -batch file of "url [output]" lines. Blank lines and lines starting
+// with # are skipped.
+func readBatch(path string) ([]batchEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []batchEntry
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"url [output]\", got %d fields", path, n, len(fields))
+		}
+		entry := batchEntry{line: n, url: fields[0]}
+		if len(fields) == 2 {
+			entry.output = fields[1]
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no URLs", path)
+	}
+	return entries, nil
+}
+
+// Download each entry in turn with its own Downloader, carrying on past
+// failures, and report how many succeeded
+func (j *job) downloadBatch(ctx context.Context, out m3u8dl.EventWriter, opts m3u8dl.Options, entries []batchEntry) error {
+	var failed []string
+	for i, entry := range entries {
+		report(out, "batch_entry", map[string]interface{}{"index": i + 1, "total": len(entries), "url": entry.url},
+			"\n📼 [%d/%d] %s\n", i+1, len(entries), entry.url)
+		entryOpts := opts
+		entryOpts.URL = entry.url
+		entryOpts.OutputFile = entry.output
+		if err := j.download(ctx, out, entryOpts); err != nil {
+			failed = append(failed, fmt.Sprintf("line %d: %s", entry.line, entry.url))
+			var exit *exitError
+			if errors.As(err, &exit) && exit.code == exitInterrupted {
+				return err
+			}
+		}
+	}
+
+	succeeded := len(entries) - len(failed)
+	if len(failed) == 0 {
+		report(out, "batch_done", map[string]interface{}{"succeeded": succeeded, "failed": failed},
+			"\n📋 Batch complete: all %d downloads succeeded\n", succeeded)
+		return nil
+	}
+	report(out, "batch_done", map[string]interface{}{"succeeded": succeeded, "failed": failed},
+		"\n📋 Batch complete: %d succeeded, %d failed:\n   %s\n", succeeded, len(failed), strings.Join(failed, "\n   "))
+	return &exitError{code: exitBatch, message: fmt.Sprintf("%d of %d batch downloads failed", len(failed), len(entries))}
+}
@@ -0,0 +1,3 @@
+module github.com/vizshrc/m3u8-downloader
+
+go 1.21
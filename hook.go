@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/vizshrc/m3u8-downloader/m3u8dl"
+)
+
+// Run the -on-complete command through the shell with the output path in
+// $M3U8_OUTPUT and $1. Its output goes to stderr so -json stays parseable.
+func runHook(ctx context.Context, out m3u8dl.EventWriter, command, output, playlistURL string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command, "sh", output)
+	}
+	cmd.Env = append(os.Environ(), "M3U8_OUTPUT="+output, "M3U8_URL="+playlistURL)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	report(out, "hook_start", map[string]interface{}{"command": command}, "🪝 Running: %s\n", command)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		report(out, "hook_done", map[string]interface{}{"command": command, "status": 0}, "🪝 Command finished\n")
+		return nil
+	case errors.As(err, &exitErr):
+		return reportError(out, stageCode(ctx, exitHook), "-on-complete command exited with status %d", exitErr.ExitCode())
+	default:
+		return reportError(out, stageCode(ctx, exitHook), "-on-complete command failed to run: %v", err)
+	}
+}
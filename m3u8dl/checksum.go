@@ -0,0 +1,87 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Checksums maps a segment URL, URL file name or zero-based index to the
+// hex SHA-256 digest its decrypted data must have
+type Checksums map[string]string
+
+// LoadChecksums reads a sha256sum-style file: one "<digest>  <name>" line per
+// segment, where name is the segment URL, its file name or its index
+func LoadChecksums(path string) (Checksums, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	sums := make(Checksums)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		digest := strings.ToLower(fields[0])
+		if len(fields) != 2 || len(digest) != sha256.Size*2 {
+			return nil, fmt.Errorf("malformed checksum line: %q", line)
+		}
+		if _, err := hex.DecodeString(digest); err != nil {
+			return nil, fmt.Errorf("malformed checksum line: %q", line)
+		}
+		// sha256sum marks binary-mode entries with a leading '*'
+		sums[strings.TrimPrefix(fields[1], "*")] = digest
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(sums) == 0 {
+		return nil, fmt.Errorf("no checksums found in %s", path)
+	}
+	return sums, nil
+}
+
+// Expected digest for a segment, if the list has one
+func (c Checksums) lookup(segment *Segment) (string, bool) {
+	if digest, ok := c[segment.URL]; ok {
+		return digest, true
+	}
+	if digest, ok := c[strconv.Itoa(segment.Index)]; ok {
+		return digest, true
+	}
+	if u, err := url.Parse(segment.URL); err == nil {
+		if digest, ok := c[path.Base(u.Path)]; ok {
+			return digest, true
+		}
+	}
+	return "", false
+}
+
+// Compare a segment's data against its expected digest
+func (c Checksums) verify(segment *Segment, data []byte) error {
+	sum := sha256.Sum256(data)
+	return c.verifySum(segment, sum[:])
+}
+
+// Compare a segment's SHA-256 sum against its expected digest
+func (c Checksums) verifySum(segment *Segment, sum []byte) error {
+	want, ok := c.lookup(segment)
+	if !ok {
+		return nil
+	}
+	if got := hex.EncodeToString(sum); got != want {
+		return fmt.Errorf("checksum mismatch: got %s, want %s", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package m3u8dl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadChecksums(t *testing.T) {
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("b", 64)
+	tests := []struct {
+		name    string
+		content string
+		want    Checksums
+		wantErr bool
+	}{
+		{"sha256sum output", a + "  seg0.ts\n" + b + " *seg1.ts\n", Checksums{"seg0.ts": a, "seg1.ts": b}, false},
+		{"comments and blanks", "# generated\n\n  " + a + " 3  \n", Checksums{"3": a}, false},
+		{"uppercase digest", strings.ToUpper(a) + " seg0.ts\n", Checksums{"seg0.ts": a}, false},
+		{"full URL", a + " https://cdn.example.com/seg0.ts\n", Checksums{"https://cdn.example.com/seg0.ts": a}, false},
+		{"missing name", a + "\n", nil, true},
+		{"short digest", "abc seg0.ts\n", nil, true},
+		{"not hex", strings.Repeat("z", 64) + " seg0.ts\n", nil, true},
+		{"extra field", a + " seg0.ts extra\n", nil, true},
+		{"empty", "# nothing here\n", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sums.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadChecksums(path)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("LoadChecksums accepted %q", tt.content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadChecksums = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksumsVerify(t *testing.T) {
+	data := []byte("segment data")
+	sum := sha256.Sum256(data)
+	digest := hex.EncodeToString(sum[:])
+	segment := &Segment{Index: 4, URL: "https://cdn.example.com/path/seg4.ts?token=x"}
+
+	tests := []struct {
+		name    string
+		sums    Checksums
+		wantErr bool
+	}{
+		{"by URL", Checksums{segment.URL: digest}, false},
+		{"by index", Checksums{"4": digest}, false},
+		{"by file name", Checksums{"seg4.ts": digest}, false},
+		{"not listed", Checksums{"seg5.ts": strings.Repeat("0", 64)}, false},
+		{"mismatch", Checksums{"seg4.ts": strings.Repeat("0", 64)}, true},
+	}
+	for _, tt := range tests {
+		err := tt.sums.verify(segment, data)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: verify = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestChecksumsSkipAudioTrack(t *testing.T) {
+	video := []byte("Gvideo")
+	sum := sha256.Sum256(video)
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",LANGUAGE=\"en\",NAME=\"English\",DEFAULT=YES,URI=\"audio/index.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"aud\"\nvideo/index.m3u8\n"),
+		// Both tracks have a segment 0 named seg0.ts
+		"/video/index.m3u8": serveString("#EXTM3U\n#EXTINF:1,\nseg0.ts\n#EXT-X-ENDLIST\n"),
+		"/audio/index.m3u8": serveString("#EXTM3U\n#EXTINF:1,\nseg0.ts\n#EXT-X-ENDLIST\n"),
+		"/video/seg0.ts":    serveString(string(video)),
+		"/audio/seg0.ts":    serveString("Gaudio"),
+	})
+	for _, key := range []string{"0", "seg0.ts"} {
+		_, _, err := downloadString(t, Options{
+			URL:       server.URL + "/master.m3u8",
+			Checksums: Checksums{key: hex.EncodeToString(sum[:])},
+			Retries:   -1,
+		})
+		if err != nil {
+			t.Errorf("checksum listed as %q: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Containers recognized in segment data
+const (
+	ContainerTS   = "MPEG-TS"
+	ContainerFMP4 = "fMP4"
+	ContainerAAC  = "AAC"
+	ContainerMP3  = "MP3"
+)
+
+// Bytes of the first segment read to recognize its container
+const probeSize = 4096
+
+// Output extension for each container
+var containerExts = map[string]string{
+	ContainerTS:   ".ts",
+	ContainerFMP4: ".mp4",
+	ContainerAAC:  ".aac",
+	ContainerMP3:  ".mp3",
+}
+
+// Segment file extensions kept on disk; anything else is stored as .ts
+var segmentExts = map[string]bool{
+	".ts": true, ".aac": true, ".ac3": true, ".ec3": true, ".mp3": true,
+	".mp4": true, ".m4s": true, ".m4a": true, ".m4v": true, ".cmfv": true, ".cmfa": true,
+	".vtt": true, ".webvtt": true, ".jpg": true, ".jpeg": true, ".png": true,
+}
+
+// Containers implied by segment file extensions, for when probing fails
+var extContainers = map[string]string{
+	".ts":   ContainerTS,
+	".mp4":  ContainerFMP4,
+	".m4s":  ContainerFMP4,
+	".m4a":  ContainerFMP4,
+	".m4v":  ContainerFMP4,
+	".cmfv": ContainerFMP4,
+	".cmfa": ContainerFMP4,
+	".aac":  ContainerAAC,
+	".mp3":  ContainerMP3,
+}
+
+// File extension of a segment URL's path, ".ts" when missing or unknown.
+// Only the path counts, so "seg.m4s?token=a.ts" is still .m4s.
+func segmentExt(segmentURL string) string {
+	u, err := url.Parse(segmentURL)
+	if err != nil {
+		return ".ts"
+	}
+	ext := strings.ToLower(path.Ext(u.Path))
+	if !segmentExts[ext] {
+		return ".ts"
+	}
+	return ext
+}
+
+// Classify the start of a (decrypted) segment, or "" when unrecognized
+func detectContainer(data []byte) string {
+	if looksLikeTS(data) {
+		return ContainerTS
+	}
+	if len(data) >= 8 {
+		switch string(data[4:8]) {
+		case "ftyp", "styp", "moof", "moov", "sidx":
+			return ContainerFMP4
+		}
+	}
+	// Packed audio starts with an ID3 tag carrying its timestamp
+	if len(data) >= 10 && bytes.HasPrefix(data, []byte("ID3")) {
+		size := int(data[6]&0x7f)<<21 | int(data[7]&0x7f)<<14 | int(data[8]&0x7f)<<7 | int(data[9]&0x7f)
+		if 10+size >= len(data) {
+			return ""
+		}
+		data = data[10+size:]
+	}
+	if len(data) >= 2 && data[0] == 0xff && data[1]&0xe0 == 0xe0 {
+		// ADTS uses layer 0, MPEG audio layers 1-3
+		if data[1]&0x06 == 0 {
+			return ContainerAAC
+		}
+		return ContainerMP3
+	}
+	return ""
+}
+
+// Read the head of the first segment to learn what the stream really
+// contains, report it, and name the default output after it. A failed probe
+// is not an error; the download itself will surface any problem.
+func (d *Downloader) probeContainer(ctx context.Context) {
+	if d.initSegment != nil {
+		d.container = ContainerFMP4
+	} else if len(d.segments) > 0 && !d.opts.DryRun {
+		head, err := d.segmentHead(ctx, d.segments[0])
+		if err != nil {
+			return
+		}
+		d.container = detectContainer(head)
+	}
+	// Fall back to what the segment names claim
+	if d.container == "" && len(d.segments) > 0 {
+		d.container = extContainers[d.segments[0].Ext]
+	}
+	if d.container == "" {
+		return
+	}
+	ext := containerExts[d.container]
+
+	if d.container != ContainerTS {
+		d.emit("container", map[string]interface{}{"container": d.container},
+			"📦 Segments contain %s, not MPEG-TS\n", d.container)
+		// Only TS has packets to check decrypted data against
+		d.opts.SkipTSCheck = true
+	}
+	if d.opts.OutputFile == "" {
+		d.outputFile = strings.TrimSuffix(DefaultOutputFile, filepath.Ext(DefaultOutputFile)) + ext
+	} else if !d.opts.Remux && !d.toStdout() && !strings.EqualFold(filepath.Ext(d.outputFile), ext) &&
+		!strings.EqualFold(filepath.Ext(d.outputFile), ".mp4") {
+		d.emit("warning", map[string]interface{}{"message": "output extension doesn't match the stream", "container": d.container},
+			"⚠️  Segments contain %s but the output is named %s, consider %s\n", d.container, d.outputFile, ext)
+	}
+}
+
+// First probeSize bytes of a segment, decrypted
+func (d *Downloader) segmentHead(ctx context.Context, segment *Segment) ([]byte, error) {
+	resp, body, err := d.openSegment(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Server ignored the Range header, skip to the range ourselves
+	if segment.ByteLength > 0 && resp.StatusCode == http.StatusOK {
+		if _, err := io.CopyN(io.Discard, body, segment.ByteStart); err != nil {
+			return nil, err
+		}
+	}
+	if len(segment.Key) > 0 && len(segment.IV) > 0 {
+		if body, err = d.newCBCReader(body, segment.Key, segment.IV); err != nil {
+			return nil, err
+		}
+	}
+
+	head := make([]byte, probeSize)
+	n, err := io.ReadFull(body, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
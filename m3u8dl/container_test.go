@@ -0,0 +1,54 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSegmentExt(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://cdn.example.com/seg0.ts", ".ts"},
+		{"https://cdn.example.com/seg0.ts?token=abc.def", ".ts"},
+		{"https://cdn.example.com/seg0.m4s?token=a.ts", ".m4s"},
+		{"https://cdn.example.com/seg0.AAC?Expires=1&Signature=x/y.z", ".aac"},
+		{"https://cdn.example.com/seg0.mp4#t=10.5", ".mp4"},
+		{"https://cdn.example.com/segment?id=4.m4s", ".ts"},
+		{"https://cdn.example.com/seg0.php?file=a.ts", ".ts"},
+		{"https://cdn.example.com/v1.2/seg", ".ts"},
+		{"seg0.vtt?lang=en", ".vtt"},
+	}
+	for _, tt := range tests {
+		if got := segmentExt(tt.url); got != tt.want {
+			t.Errorf("segmentExt(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestQueryStringSegments(t *testing.T) {
+	// Segments differ only in their query strings
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXTINF:1,\nseg.ts?part=0&sig=a/b.c\n#EXTINF:1,\nseg.ts?part=1&sig=d/e.f\n" +
+			"#EXTINF:1,\nseg.ts?part=2&sig=g/h.i\n#EXT-X-ENDLIST\n"),
+		"/seg.ts": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Gpart" + r.URL.Query().Get("part") + "|"))
+		},
+	})
+	for _, dedupe := range []bool{false, true} {
+		got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8", Dedupe: dedupe})
+		if err != nil {
+			t.Fatalf("Dedupe=%v: %v", dedupe, err)
+		}
+		if got != "Gpart0|Gpart1|Gpart2|" {
+			t.Errorf("Dedupe=%v: output = %q", dedupe, got)
+		}
+	}
+	for _, segment := range parseURL(t, server.URL+"/video.m3u8").Segments() {
+		if segment.Ext != ".ts" {
+			t.Errorf("segment %d extension = %q", segment.Index, segment.Ext)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadCookies seeds a cookie jar from either a cookies.txt file (Netscape
+// format, as exported by browser extensions) or a raw "name=value; ..."
+// Cookie header value, which is scoped to the playlist URL's host.
+func LoadCookies(jar http.CookieJar, value, playlistURL string) error {
+	if info, err := os.Stat(value); err == nil && !info.IsDir() {
+		return loadCookieFile(jar, value)
+	}
+
+	u, err := url.Parse(playlistURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid playlist url for cookies: %s", playlistURL)
+	}
+
+	header := http.Header{"Cookie": {value}}
+	cookies := (&http.Request{Header: header}).Cookies()
+	if len(cookies) == 0 {
+		return fmt.Errorf("no cookies found in %q", value)
+	}
+	for _, cookie := range cookies {
+		cookie.Path = "/"
+	}
+	jar.SetCookies(&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}, cookies)
+	return nil
+}
+
+// Parse a Netscape cookies.txt file into the jar
+func loadCookieFile(jar http.CookieJar, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loaded := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// curl and browser exports mark HttpOnly cookies with this prefix
+		line = strings.TrimPrefix(line, "#HttpOnly_")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expiry, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return fmt.Errorf("malformed cookies.txt line: %q", line)
+		}
+
+		cookie := &http.Cookie{
+			Name:   fields[5],
+			Value:  fields[6],
+			Path:   fields[2],
+			Secure: strings.EqualFold(fields[3], "TRUE"),
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		if strings.EqualFold(fields[1], "TRUE") {
+			cookie.Domain = host
+		}
+		if expiry, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiry > 0 {
+			cookie.Expires = time.Unix(expiry, 0)
+		}
+
+		scheme := "http"
+		if cookie.Secure {
+			scheme = "https"
+		}
+		jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: cookie.Path}, []*http.Cookie{cookie})
+		loaded++
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if loaded == 0 {
+		return fmt.Errorf("no cookies found in %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+)
+
+// Whether data starts like an MPEG-TS stream: 188-byte packets led by a sync byte
+func looksLikeTS(data []byte) bool {
+	if len(data) == 0 || data[0] != tsSyncByte {
+		return false
+	}
+	return len(data) <= tsPacketSize || data[tsPacketSize] == tsSyncByte
+}
+
+// Build a 16-byte big-endian IV from a media sequence number
+func sequenceIV(seq int64) []byte {
+	iv := make([]byte, 16)
+	binary.BigEndian.PutUint64(iv[8:], uint64(seq))
+	return iv
+}
+
+// AES ciphers by key, shared by every segment encrypted with it. A
+// cipher.Block is safe for concurrent use; the CBC mode around it is not,
+// so each segment still gets its own.
+type cipherCache struct {
+	mu     sync.Mutex
+	blocks map[string]cipher.Block
+}
+
+// Check the key and IV sizes and set up a CBC decrypter
+func (c *cipherCache) decrypter(key, iv []byte) (cipher.BlockMode, error) {
+	if len(key) != 16 {
+		return nil, fmt.Errorf("AES-128 key must be 16 bytes, got %d", len(key))
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, fmt.Errorf("IV must be %d bytes, got %d", aes.BlockSize, len(iv))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	block, ok := c.blocks[string(key)]
+	if !ok {
+		var err error
+		if block, err = aes.NewCipher(key); err != nil {
+			return nil, err
+		}
+		if c.blocks == nil {
+			c.blocks = make(map[string]cipher.Block)
+		}
+		c.blocks[string(key)] = block
+	}
+	return cipher.NewCBCDecrypter(block, iv), nil
+}
+
+// AES-128 decryption
+func (d *Downloader) decryptAES128(ciphertext, key, iv []byte) ([]byte, error) {
+	mode, err := d.ciphers.decrypter(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) == 0 {
+		return nil, errors.New("empty ciphertext")
+	}
+	// Truncated transfers leave a partial block, which CryptBlocks would panic on
+	if len(ciphertext)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("ciphertext length %d is not a multiple of the AES block size", len(ciphertext))
+	}
+
+	plaintext := make([]byte, len(ciphertext))
+	mode.CryptBlocks(plaintext, ciphertext)
+
+	return unpadPKCS7(plaintext)
+}
+
+// Strip PKCS7 padding; a wrong key usually leaves invalid padding behind
+func unpadPKCS7(plaintext []byte) ([]byte, error) {
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen < 1 || padLen > aes.BlockSize || padLen > len(plaintext) {
+		return nil, errors.New("invalid PKCS7 padding")
+	}
+	padding := plaintext[len(plaintext)-padLen:]
+	if !bytes.Equal(padding, bytes.Repeat([]byte{byte(padLen)}, padLen)) {
+		return nil, errors.New("invalid PKCS7 padding")
+	}
+	return plaintext[:len(plaintext)-padLen], nil
+}
+
+// Decrypts an AES-128-CBC stream as it is read, holding back the last block
+// until EOF so its PKCS7 padding can be stripped
+type cbcReader struct {
+	src     io.Reader
+	mode    cipher.BlockMode
+	buf     []byte
+	pending []byte // Ciphertext not decrypted yet
+	out     []byte // Plaintext not returned yet
+	read    int64
+	err     error
+}
+
+func (d *Downloader) newCBCReader(src io.Reader, key, iv []byte) (*cbcReader, error) {
+	mode, err := d.ciphers.decrypter(key, iv)
+	if err != nil {
+		return nil, err
+	}
+	return &cbcReader{src: src, mode: mode}, nil
+}
+
+func (r *cbcReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.fill()
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// Read more ciphertext and decrypt every block but the last
+func (r *cbcReader) fill() {
+	if r.buf == nil {
+		r.buf = make([]byte, 32<<10)
+	}
+	n, err := r.src.Read(r.buf)
+	r.pending = append(r.pending, r.buf[:n]...)
+	r.read += int64(n)
+
+	if err == io.EOF {
+		r.err = io.EOF
+		switch {
+		case r.read == 0:
+			r.err = errors.New("failed to decrypt: empty ciphertext")
+		case len(r.pending)%aes.BlockSize != 0:
+			r.err = fmt.Errorf("failed to decrypt: ciphertext length %d is not a multiple of the AES block size", r.read)
+		default:
+			r.mode.CryptBlocks(r.pending, r.pending)
+			if r.out, err = unpadPKCS7(r.pending); err != nil {
+				r.err = fmt.Errorf("failed to decrypt: %w", err)
+			}
+			r.pending = nil
+		}
+		return
+	}
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	ready := len(r.pending) / aes.BlockSize * aes.BlockSize
+	if ready == len(r.pending) {
+		ready -= aes.BlockSize
+	}
+	if ready > 0 {
+		r.out = make([]byte, ready)
+		r.mode.CryptBlocks(r.out, r.pending[:ready])
+		r.pending = append([]byte(nil), r.pending[ready:]...)
+	}
+}
@@ -0,0 +1,95 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"net/http"
+	"testing"
+)
+
+func TestUnpadPKCS7(t *testing.T) {
+	block := func(tail ...byte) []byte {
+		return append(bytes.Repeat([]byte{'x'}, aes.BlockSize-len(tail)), tail...)
+	}
+	tests := []struct {
+		name  string
+		data  []byte
+		want  int
+		valid bool
+	}{
+		{"one byte", block(1), aes.BlockSize - 1, true},
+		{"four bytes", block(4, 4, 4, 4), aes.BlockSize - 4, true},
+		{"whole block", bytes.Repeat([]byte{16}, aes.BlockSize), 0, true},
+		{"zero", block(0), 0, false},
+		{"past the block size", block(17), 0, false},
+		{"longer than the data", []byte{5}, 0, false},
+		{"mismatched bytes", block(3, 2, 3), 0, false},
+	}
+	for _, tt := range tests {
+		got, err := unpadPKCS7(tt.data)
+		if tt.valid && (err != nil || len(got) != tt.want) {
+			t.Errorf("%s: got %d bytes, %v, want %d bytes", tt.name, len(got), err, tt.want)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: padding accepted", tt.name)
+		}
+	}
+}
+
+func TestDecryptMalformedCiphertext(t *testing.T) {
+	iv := make([]byte, aes.BlockSize)
+	// A block whose last plaintext byte is no valid padding length
+	badPadding := make([]byte, aes.BlockSize)
+	block, _ := aes.NewCipher(testKey)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(badPadding, bytes.Repeat([]byte{0xff}, aes.BlockSize))
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		key        []byte
+	}{
+		{"empty", nil, testKey},
+		{"partial block", make([]byte, aes.BlockSize+5), testKey},
+		{"bad padding", badPadding, testKey},
+		{"short key", make([]byte, aes.BlockSize), testKey[:8]},
+	}
+	d := NewDownloader(Options{Events: discardWriter{}})
+	for _, tt := range tests {
+		if _, err := d.decryptAES128(tt.ciphertext, tt.key, iv); err == nil {
+			t.Errorf("%s: decrypted without an error", tt.name)
+		}
+	}
+
+	plain := []byte("G segment data")
+	got, err := d.decryptAES128(encryptSegment(t, plain, testKey, iv), testKey, iv)
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("valid ciphertext: got %q, %v", got, err)
+	}
+}
+
+func TestDecryptReportsSegmentMethod(t *testing.T) {
+	// An encrypted segment followed by clear ones, the last key line is NONE
+	plain := []byte("\x00\x00\x00\x10ftypisom\x00\x00\x02\x00")
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/index.m3u8": serveString("#EXTM3U\n#EXT-X-TARGETDURATION:4\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n#EXTINF:4,\nseg0.mp4\n" +
+			"#EXT-X-KEY:METHOD=NONE\n#EXTINF:4,\nseg1.mp4\n#EXT-X-ENDLIST\n"),
+		"/key.bin":  serveString(string(testKey)),
+		"/seg0.mp4": serveString(string(encryptSegment(t, plain, testKey, sequenceIV(0)))),
+		"/seg1.mp4": serveString(string(plain)),
+	})
+	d := parseURL(t, server.URL+"/index.m3u8")
+
+	test, err := d.TestDecrypt(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test.Segment != 0 || test.Method != "AES-128" || test.KeyLength != len(testKey) || !test.IVFromSeq {
+		t.Errorf("got %+v, want segment 0 with its AES-128 key and sequence IV", test)
+	}
+	if !test.OK() {
+		t.Errorf("segment did not decrypt into a container: %+v", test)
+	}
+}
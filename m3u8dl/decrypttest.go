@@ -0,0 +1,88 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DecryptTest describes how TestDecrypt decrypted the first encrypted segment
+type DecryptTest struct {
+	Segment   int    `json:"segment"`
+	URL       string `json:"url"`
+	Method    string `json:"method"`
+	KeyLength int    `json:"key_length"`
+	IV        string `json:"iv"`                  // 0x-prefixed hex
+	IVFromSeq bool   `json:"iv_from_sequence"`    // No IV attribute, derived from the media sequence number
+	Bytes     int    `json:"bytes"`               // Encrypted size
+	Container string `json:"container,omitempty"` // Recognized in the decrypted data; "" when it looks like garbage
+	Error     string `json:"error,omitempty"`     // Why decryption itself failed, e.g. bad padding
+}
+
+// OK reports whether the segment decrypted into a recognizable container
+func (t DecryptTest) OK() bool {
+	return t.Error == "" && t.Container != ""
+}
+
+// TestDecrypt fetches only the first encrypted segment after ParseM3U8,
+// decrypts it and checks the result, separating key and IV problems from
+// download or merge ones. The error is for a test that couldn't run at all.
+func (d *Downloader) TestDecrypt(ctx context.Context) (DecryptTest, error) {
+	var segment *Segment
+	for _, s := range d.segments {
+		if len(s.Key) > 0 {
+			segment = s
+			break
+		}
+	}
+	if segment == nil {
+		return DecryptTest{}, errors.New("playlist is not encrypted")
+	}
+
+	test := DecryptTest{
+		Segment:   segment.Index,
+		URL:       segment.URL,
+		Method:    segment.KeyMethod,
+		KeyLength: len(segment.Key),
+		IV:        "0x" + hex.EncodeToString(segment.IV),
+		IVFromSeq: bytes.Equal(segment.IV, sequenceIV(segment.Sequence)),
+	}
+	data, err := d.rawSegment(ctx, segment)
+	if err != nil {
+		return test, err
+	}
+	test.Bytes = len(data)
+	decrypted, err := d.decryptAES128(data, segment.Key, segment.IV)
+	if err != nil {
+		test.Error = err.Error()
+		return test, nil
+	}
+	test.Container = detectContainer(decrypted)
+	return test, nil
+}
+
+// Bytes of a segment as served, without decrypting them
+func (d *Downloader) rawSegment(ctx context.Context, segment *Segment) ([]byte, error) {
+	resp, body, err := d.openSegment(ctx, segment)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	// Server ignored the Range header, slice the requested range ourselves
+	if segment.ByteLength > 0 && resp.StatusCode == http.StatusOK {
+		end := segment.ByteStart + segment.ByteLength
+		if end > int64(len(data)) {
+			return nil, fmt.Errorf("byte range exceeds resource size %d", len(data))
+		}
+		data = data[segment.ByteStart:end]
+	}
+	return data, nil
+}
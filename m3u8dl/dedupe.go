@@ -0,0 +1,88 @@
+package m3u8dl
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// Segments referencing the same bytes, fetched once and handed to every
+// reference. Entries are dropped once their last reference has taken them.
+type dedupeCache struct {
+	mu      sync.Mutex
+	entries map[string]*dedupeEntry
+}
+
+type dedupeEntry struct {
+	refs    int           // References dispatched but not yet served
+	started bool          // A worker is fetching the data
+	done    chan struct{} // Closed once data or err is set
+	data    []byte
+	err     error
+}
+
+// Identity of a segment's decrypted data. The key and IV are included since
+// the same ciphertext decrypts differently under another IV.
+func dedupeKey(segment *Segment) string {
+	return fmt.Sprintf("%s@%d+%d|%s|%s", segment.URL, segment.ByteStart, segment.ByteLength,
+		hex.EncodeToString(segment.Key), hex.EncodeToString(segment.IV))
+}
+
+// Count a dispatched segment; true when it repeats an earlier one
+func (c *dedupeCache) add(segment *Segment) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]*dedupeEntry)
+	}
+	key := dedupeKey(segment)
+	entry, ok := c.entries[key]
+	if !ok {
+		entry = &dedupeEntry{done: make(chan struct{})}
+		c.entries[key] = entry
+	}
+	entry.refs++
+	return ok
+}
+
+// Whether other dispatched segments still share this segment's data
+func (c *dedupeCache) shared(segment *Segment) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[dedupeKey(segment)]
+	return ok && (entry.refs > 1 || entry.started)
+}
+
+// Fetch a segment, or wait for the worker already fetching the same data
+func (d *Downloader) fetchShared(ctx context.Context, segment *Segment, retries int) ([]byte, error) {
+	key := dedupeKey(segment)
+	d.dedupe.mu.Lock()
+	entry, ok := d.dedupe.entries[key]
+	if !ok {
+		d.dedupe.mu.Unlock()
+		return d.fetchSegment(ctx, segment, retries)
+	}
+	leader := !entry.started
+	entry.started = true
+	d.dedupe.mu.Unlock()
+
+	if leader {
+		entry.data, entry.err = d.fetchSegment(ctx, segment, retries)
+		close(entry.done)
+	} else {
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	d.dedupe.mu.Lock()
+	entry.refs--
+	if entry.refs == 0 {
+		delete(d.dedupe.entries, key)
+	}
+	d.dedupe.mu.Unlock()
+	return entry.data, entry.err
+}
@@ -0,0 +1,1175 @@
+// Package m3u8dl downloads HLS (m3u8) playlists with concurrent segment fetching.
+package m3u8dl
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultWorkers    = 32 // Concurrent downloads
+	DefaultRetries    = 3  // Retry failed segments
+	DefaultTimeout    = 30 * time.Second
+	DefaultBackoff    = time.Second // Base delay between retries
+	DefaultMaxErrors  = 10          // Failed segments tolerated before aborting
+	DefaultOutputFile = "output.ts"
+	DefaultMaxBackoff = 30 * time.Second // Ceiling on a single retry delay
+
+	// Largest segment accepted, so a bogus segment URI pointing at a huge
+	// file can't exhaust memory
+	DefaultMaxSegmentSize = 512 << 20
+
+	// User-Agent sent when Options.UserAgent is empty
+	DefaultUserAgent = "Mozilla/5.0"
+)
+
+// Options configures a Downloader
+type Options struct {
+	URL        string        // M3U8 playlist URL, local path, file:// URL or data: URI
+	BaseURL    string        // Base for relative segment and key URIs instead of the playlist's location; "" derives it
+	OutputFile string        // Merged output path; empty picks a default from the stream type, "-" is stdout
+	TempDir    string        // Directory holding segment files until merge
+	Workers    int           // Concurrent segment downloads
+	Retries    int           // Retries per failed segment, playlist or key; 0 uses DefaultRetries, negative disables
+	Timeout    time.Duration // Per-request HTTP timeout
+	Backoff    time.Duration // Base retry delay, growing with each attempt
+	Seed       int64         // Seed for retry jitter; 0 seeds from the clock
+	MaxErrors  int           // Failed segments tolerated before aborting; 0 uses DefaultMaxErrors, negative never aborts
+
+	MaxDuration time.Duration // Wall-clock cap on DownloadSegments; 0 means none
+
+	// Retries allowed across all segments before the download aborts, to
+	// bound the time spent on a failing origin; 0 means no limit
+	MaxTotalRetries int
+
+	MaxBackoff  time.Duration // Cap on a single retry delay; 0 uses DefaultMaxBackoff
+	RetryBudget time.Duration // Time a segment may spend on attempts before failing; 0 means no limit
+
+	ContinueOnError bool // Leave gaps for failed segments instead of failing the download
+	SkipTSCheck     bool // Don't check that decrypted segments look like MPEG-TS
+	LowMemory       bool // Stream segment bodies to disk instead of buffering them; ignored with Stream
+
+	Checksums Checksums // Expected SHA-256 of decrypted segments; nil skips verification
+
+	// Re-fetch the playlist for freshly signed segment URLs after this many
+	// 403 responses; 0 disables
+	RefreshOn403 int
+
+	Remux  bool // Convert the merged TS to MP4 with ffmpeg; implied by a .mp4 OutputFile
+	KeepTS bool // Keep the merged TS after a successful remux
+	Force  bool // Overwrite an existing output; otherwise an explicit OutputFile fails and the default one is renamed
+	Stream bool // Append segments to the output as they finish instead of using temp files
+	Resume bool // Reuse completed segments recorded in the TempDir manifest
+	Live   bool // Keep reloading the playlist until EXT-X-ENDLIST
+
+	// Leave the segment files in TempDir after merging, and the directory
+	// itself after Download, for inspecting the raw segments
+	KeepTemp bool
+
+	// Largest segment body accepted, checked against Content-Length up front
+	// and enforced while reading; 0 uses DefaultMaxSegmentSize, negative
+	// disables the check
+	MaxSegmentSize int64
+
+	// Segments held in memory ahead of the Stream writer. Once this many are
+	// downloaded but not yet written, new downloads wait for the disk to catch
+	// up, bounding memory to about this many segments; 0 uses twice Workers.
+	StreamBuffer int
+
+	// Name segment files by EXT-X-MEDIA-SEQUENCE number instead of playlist
+	// position, so files from separate runs over a live window don't collide
+	SequenceNames bool
+
+	// Write segments into a preallocated output as they finish when all sizes
+	// are known up front (HEAD or byte ranges), else merge afterwards
+	PipelineMerge bool
+
+	// Fetch each URL and byte range once and reuse the data for repeated
+	// references; merge order still follows the playlist
+	Dedupe bool
+
+	// Instead of merging, move segments into a directory named after
+	// OutputFile along with a local.m3u8 that plays them
+	KeepSegments bool
+
+	// Keep segments as with KeepSegments and also list them in a concat.txt
+	// for ffmpeg's concat demuxer; implies KeepSegments
+	ConcatList bool
+
+	// Retry segments that exhausted their retries from redundant variants
+	// (same bandwidth, resolution and codecs on another URL) of a master playlist
+	Failover bool
+
+	// Variant selection limits for master playlists; 0 means no limit
+	MaxWidth     int
+	MaxHeight    int
+	MaxBandwidth int64
+
+	// Download only segments [StartSegment, EndSegment) by zero-based playlist
+	// position; EndSegment 0 means through the last one
+	StartSegment int
+	EndSegment   int
+
+	// Download only the segments overlapping [StartTime, EndTime) of playback,
+	// applied after the segment range; EndTime 0 means through the end
+	StartTime time.Duration
+	EndTime   time.Duration
+
+	// Filter, when set, is called with each media segment as the playlist is
+	// parsed, including segments added by live reloads and those of separate
+	// audio and subtitle tracks. Segments it returns false for are left out
+	// and the rest renumbered, before any segment or time range is applied.
+	// See SkipAdBreaks.
+	Filter func(*Segment) bool
+
+	ListVariants bool   // Stop after collecting a master playlist's variants
+	DryRun       bool   // Only parse; keys are not fetched, see Summary
+	Audio        string // Language of the separate audio track to fetch (e.g. "en"); "" picks the default
+	Subtitles    string // Language of the WebVTT subtitles to save next to the output; "" skips them
+	Metadata     bool   // Have Download write a JSON sidecar, see WriteMetadata
+
+	Headers http.Header    // Extra headers sent with every playlist, key and segment request
+	Jar     http.CookieJar // Cookie jar shared by all requests; nil creates an empty one
+
+	// User-Agent for every request, e.g. "AppleCoreMedia/1.0" for origins that
+	// only serve known players; "" sends DefaultUserAgent. NoUserAgent sends
+	// none at all.
+	UserAgent   string
+	NoUserAgent bool
+
+	// HTTP Basic Auth credentials sent with every playlist, key and segment
+	// request. When both are empty, any user:password@ in URL is used instead.
+	Username string
+	Password string
+
+	RateLimit int64 // Aggregate download cap in bytes per second; 0 means unlimited
+	Insecure  bool  // Skip TLS certificate verification
+
+	// Certificates to verify servers against, e.g. from LoadCACerts for a
+	// private CA; nil uses the system roots
+	RootCAs *x509.CertPool
+
+	ConnsPerHost int // Connections kept open per host; 0 matches Workers
+
+	DNS        string // DNS server ("host" or "host:port") used instead of the system resolver
+	PreferIPv4 bool   // Try IPv4 addresses first, falling back to IPv6
+
+	// Proxy for every request, replacing HTTP_PROXY and HTTPS_PROXY; "" uses
+	// them. NoProxy lists hosts that bypass it, replacing NO_PROXY when non-nil.
+	Proxy   string
+	NoProxy []string
+
+	Events  EventWriter // Receives status and progress output; nil prints text to stdout
+	Verbose bool        // Also emit a "segment" event with the URL of each segment fetched
+
+	Logger *slog.Logger // Receives diagnostics (requests, retries, key fetches); nil discards them
+	Trace  io.Writer    // Receives every HTTP request and response, credentials redacted; nil traces nothing
+
+	// OnProgress, when set, is called after each completed segment instead of
+	// emitting a "progress" event. It may run concurrently from several
+	// workers, so it must be safe for concurrent use.
+	OnProgress func(done, total int, bytes int64)
+}
+
+type Downloader struct {
+	opts           Options
+	m3u8URL        string
+	finalURL       string // m3u8URL after redirects, as of the last fetch
+	outputDir      string
+	padWidth       int // Digits in segment file names
+	streamed       int // Segments the stream writer put into the output
+	outputFile     string
+	mp4File        string
+	boundaries     []int64 // Output offsets where a discontinuity starts
+	audio          *Media  // Separate audio rendition of the selected variant
+	audioFile      string
+	subtitles      *Media
+	subtitlesFile  string
+	client         *http.Client
+	segments       []*Segment
+	downloadedCh   chan *Segment
+	failures       []*SegmentError
+	failMu         sync.Mutex
+	wg             sync.WaitGroup
+	progress       int32
+	retriesUsed    int32 // Retries made across all segments
+	elapsed        time.Duration
+	slowestSegment int
+	slowestTime    time.Duration
+	statsMu        sync.Mutex
+	total          int32
+	totalSize      int64
+	mediaSeq       int64
+	lastSeq        int64
+	seen           map[string]bool
+	endList        bool
+	playlistType   string // EXT-X-PLAYLIST-TYPE: "VOD", "EVENT" or empty
+	targetDuration time.Duration
+	reloadStale    bool // The last live reload found no new segments
+	filtered       int  // Segments Options.Filter left out
+	dropped        bool // Options.Filter left out the last segment parsed
+	totalDuration  time.Duration
+	// Playback span of the kept segments within the whole playlist after a
+	// segment or time range; rangeEnd 0 means through the end
+	rangeStart     time.Duration
+	rangeEnd       time.Duration
+	keyCache       map[string][]byte
+	freshURLs      map[int64]string // Segment URLs by media sequence after a refresh
+	urlMu          sync.RWMutex
+	forbiddenCount int32 // 403s since the last refresh
+	refreshMu      sync.Mutex
+	refreshing     bool   // Throwaway downloader re-parsing for fresh segment URLs
+	keyMethod      string // Encryption method seen in the playlist, if any
+	ivWarned       bool   // An off-spec IV was already reported
+	initSegment    *Segment
+	container      string // Detected segment container, e.g. ContainerTS; "" if unknown
+	variants       []Variant
+	iframeVariants []Variant            // Trick-play streams, never downloaded
+	variant        *Variant             // Variant picked from the master playlist
+	mirrorURLs     []string             // Redundant variants of the chosen one
+	mirrors        []map[int64]*Segment // Usable mirrors' segments by media sequence
+	manifest       *manifest
+	pipeline       *pipelinedOutput
+	dedupe         dedupeCache
+	ciphers        cipherCache
+	limiter        *rateLimiter
+	logger         *slog.Logger
+	rng            *rand.Rand
+	rngMu          sync.Mutex
+	speed          speedMeter
+	events         EventWriter
+}
+
+func NewDownloader(opts Options) *Downloader {
+	// Fall back to defaults on invalid settings
+	if opts.UserAgent == "" {
+		opts.UserAgent = DefaultUserAgent
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.Retries == 0 {
+		opts.Retries = DefaultRetries
+	} else if opts.Retries < 0 {
+		opts.Retries = 0
+	}
+	if opts.MaxErrors == 0 {
+		opts.MaxErrors = DefaultMaxErrors
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Backoff <= 0 {
+		opts.Backoff = DefaultBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.Events == nil && opts.OutputFile == StdoutOutput {
+		opts.Events = NewTextWriter(os.Stderr)
+	} else if opts.Events == nil {
+		opts.Events = NewTextWriter(os.Stdout)
+	}
+	if opts.Jar == nil {
+		opts.Jar, _ = cookiejar.New(nil)
+	}
+	if opts.ConcatList {
+		opts.KeepSegments = true
+	}
+	if opts.MaxSegmentSize == 0 {
+		opts.MaxSegmentSize = DefaultMaxSegmentSize
+	}
+	if opts.StreamBuffer <= 0 {
+		opts.StreamBuffer = opts.Workers * 2
+	}
+	// Credentials in the URL would be lost when resolving segment URIs and
+	// would show up in every message, so move them to the options
+	opts.URL, opts.Username, opts.Password = splitUserinfo(opts.URL, opts.Username, opts.Password)
+	if opts.Logger == nil {
+		opts.Logger = slog.New(discardHandler{})
+	}
+	// Relative URIs are appended to the base
+	if opts.BaseURL != "" && !strings.HasSuffix(opts.BaseURL, "/") {
+		opts.BaseURL += "/"
+	}
+	outputFile := opts.OutputFile
+	if outputFile == "" {
+		outputFile = DefaultOutputFile
+	}
+	d := &Downloader{
+		opts:         opts,
+		events:       opts.Events,
+		m3u8URL:      opts.URL,
+		outputDir:    opts.TempDir,
+		padWidth:     6,
+		outputFile:   outputFile,
+		client:       &http.Client{Timeout: opts.Timeout, Jar: opts.Jar, Transport: newTransport(opts)},
+		segments:     make([]*Segment, 0),
+		downloadedCh: make(chan *Segment, opts.StreamBuffer),
+		keyCache:     make(map[string][]byte),
+		seen:         make(map[string]bool),
+		logger:       opts.Logger,
+	}
+	d.client.CheckRedirect = d.logRedirect
+	if opts.Trace != nil {
+		d.client.Transport = &tracingTransport{next: d.client.Transport, w: opts.Trace}
+	}
+	if opts.RateLimit > 0 {
+		d.limiter = newRateLimiter(opts.RateLimit)
+	}
+	seed := opts.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	d.rng = rand.New(rand.NewSource(seed))
+	return d
+}
+
+// Options for a downloader sharing this download, such as the audio track's.
+// Retries was already normalized, and NewDownloader would turn a disabled 0
+// back into DefaultRetries.
+func (d *Downloader) childOptions() Options {
+	opts := d.opts
+	if opts.Retries == 0 {
+		opts.Retries = -1
+	}
+	return opts
+}
+
+// Download parses, downloads and merges a playlist in one call, returning
+// the download's Stats even when a later step fails
+func Download(ctx context.Context, opts Options) (Stats, error) {
+	if opts.TempDir == "" {
+		tempDir, err := os.MkdirTemp("", "m3u8_temp_")
+		if err != nil {
+			return Stats{}, err
+		}
+		opts.TempDir = tempDir
+	}
+
+	d := NewDownloader(opts)
+	defer func() {
+		if opts.KeepTemp {
+			d.discardPipeline()
+			d.emit("temp_kept", map[string]interface{}{"dir": opts.TempDir}, "🗂️  Temp files kept in %s\n", opts.TempDir)
+		} else {
+			d.Cleanup()
+		}
+	}()
+
+	if err := d.ParseM3U8(ctx); err != nil {
+		return Stats{}, err
+	}
+	if err := d.DownloadSegments(ctx); err != nil {
+		return d.Stats(), err
+	}
+	if err := d.MergeSegments(); err != nil {
+		return d.Stats(), err
+	}
+	if err := d.DownloadAudio(ctx); err != nil {
+		return d.Stats(), err
+	}
+	if err := d.DownloadSubtitles(ctx); err != nil {
+		return d.Stats(), err
+	}
+	if err := d.Remux(ctx); err != nil {
+		return d.Stats(), err
+	}
+	if opts.Metadata {
+		if _, err := d.WriteMetadata(); err != nil {
+			return d.Stats(), err
+		}
+	}
+	return d.Stats(), nil
+}
+
+// OutputFile returns the path the merged output is written to
+func (d *Downloader) OutputFile() string {
+	return d.outputFile
+}
+
+// TotalDuration returns the summed EXTINF duration of all parsed segments
+func (d *Downloader) TotalDuration() time.Duration {
+	return d.totalDuration
+}
+
+// StartTime returns the wall-clock time of the first segment to download
+// from EXT-X-PROGRAM-DATE-TIME, or the zero time when the playlist has none
+func (d *Downloader) StartTime() time.Time {
+	if len(d.segments) == 0 {
+		return time.Time{}
+	}
+	return d.segments[0].ProgramDateTime
+}
+
+// Variants returns the streams listed by the master playlist, if there was one
+func (d *Downloader) Variants() []Variant {
+	return d.variants
+}
+
+// IFrameVariants returns the EXT-X-I-FRAME-STREAM-INF trick-play streams of
+// the master playlist, which hold only keyframes and are never selected
+func (d *Downloader) IFrameVariants() []Variant {
+	return d.iframeVariants
+}
+
+// Segments returns the parsed media segments in playlist order
+func (d *Downloader) Segments() []*Segment {
+	return d.segments
+}
+
+// Download a single segment with retry logic
+func (d *Downloader) downloadSegment(ctx context.Context, segment *Segment, retries int) error {
+	// Skip segments a previous run already completed
+	if d.manifest != nil && d.manifest.completed(segment.Index, d.segmentPath(segment)) {
+		d.reportProgress()
+		return nil
+	}
+	if d.opts.RetryBudget > 0 {
+		segment.retryDeadline = time.Now().Add(d.opts.RetryBudget)
+	}
+
+	if d.opts.Verbose {
+		url := d.segmentURL(segment)
+		d.emit("segment", map[string]interface{}{"index": segment.Index, "url": url},
+			"\r🔗 Segment %d: %s\n", segment.Index, url)
+	}
+
+	// Write the body straight to disk instead of buffering it; the stream
+	// writer and pipelined output need the data in memory either way
+	if d.opts.LowMemory && !d.opts.Stream && d.pipeline == nil && !(d.opts.Dedupe && d.dedupe.shared(segment)) {
+		size, err := d.saveSegment(ctx, segment, retries)
+		if err != nil {
+			return err
+		}
+		atomic.AddInt64(&d.totalSize, size)
+		if d.manifest != nil {
+			d.manifest.markDone(segment.Index, size)
+		}
+		d.reportProgress()
+		return nil
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	if d.opts.Dedupe {
+		data, err = d.fetchShared(ctx, segment, retries)
+	} else {
+		data, err = d.fetchSegment(ctx, segment, retries)
+	}
+	if err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&d.totalSize, int64(len(data)))
+
+	if d.pipeline != nil {
+		if err := d.pipeline.write(segment, data); err != nil {
+			return err
+		}
+		d.reportProgress()
+		return nil
+	}
+
+	// Hand off to the in-order stream writer
+	if d.opts.Stream {
+		segment.data = data
+		d.downloadedCh <- segment
+		d.reportProgress()
+		return nil
+	}
+
+	// Save segment
+	if err := writeFileAtomic(d.segmentPath(segment), data); err != nil {
+		return err
+	}
+
+	if d.manifest != nil {
+		d.manifest.markDone(segment.Index, int64(len(data)))
+	}
+	d.reportProgress()
+	return nil
+}
+
+// Build an outbound playlist, key or segment request with the User-Agent,
+// credentials and user-supplied headers; explicit User-Agent or
+// Authorization headers win. Cookies come from the client's jar.
+func (d *Downloader) newRequest(ctx context.Context, method, rawURL string) (*http.Request, error) {
+	if strings.HasPrefix(rawURL, "file://") {
+		// Like segmentBase, but without finalURL, which live reloads rewrite
+		base := d.opts.BaseURL
+		if base == "" {
+			base = d.m3u8URL
+		}
+		if err := checkLocalAccess(base, rawURL); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// An empty value keeps net/http from adding its own
+	if d.opts.NoUserAgent {
+		req.Header.Set("User-Agent", "")
+	} else {
+		req.Header.Set("User-Agent", d.opts.UserAgent)
+	}
+	if d.opts.Username != "" || d.opts.Password != "" {
+		req.SetBasicAuth(d.opts.Username, d.opts.Password)
+	}
+	for key, values := range d.opts.Headers {
+		req.Header.Del(key)
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	return req, nil
+}
+
+// ParseHeader splits a "Key: Value" header string on its first colon
+func ParseHeader(header string) (string, string, error) {
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("invalid header %q, expected \"Key: Value\"", header)
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// Send the request for a segment and wrap its body in the rate limiter and
+// content decoder. Errors are worth retrying; on success the caller closes
+// resp.Body.
+func (d *Downloader) openSegment(ctx context.Context, segment *Segment) (*http.Response, io.Reader, error) {
+	req, err := d.newRequest(ctx, "GET", d.segmentURL(segment))
+	if err != nil {
+		return nil, nil, err
+	}
+	if segment.ByteLength > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", segment.ByteStart, segment.ByteStart+segment.ByteLength-1))
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		d.logger.Debug("segment request failed", "index", segment.Index, "url", req.URL.String(), "error", err)
+		return nil, nil, err
+	}
+	d.logger.Debug("segment response", "index", segment.Index, "url", req.URL.String(), "status", resp.StatusCode,
+		"length", resp.ContentLength)
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		// Signed URLs may have expired; the retry picks up refreshed ones
+		if resp.StatusCode == http.StatusForbidden {
+			d.noteForbidden(ctx)
+		}
+		return nil, nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	if err := d.checkSegmentSize(resp.ContentLength); err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+
+	var body io.Reader = resp.Body
+	if d.limiter != nil {
+		body = &limitedReader{ctx: ctx, r: body, limiter: d.limiter}
+	}
+	// Go only decompresses transparently when it asked for gzip itself, which
+	// a user-supplied Accept-Encoding header defeats
+	body, err = decodeContent(body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		resp.Body.Close()
+		return nil, nil, err
+	}
+	if d.opts.MaxSegmentSize > 0 {
+		body = &sizeLimitReader{r: body, max: d.opts.MaxSegmentSize}
+	}
+	return resp, body, nil
+}
+
+// Fetch and decrypt segment data, retrying on failure
+func (d *Downloader) fetchSegment(ctx context.Context, segment *Segment, retries int) ([]byte, error) {
+	resp, body, err := d.openSegment(ctx, segment)
+	if err != nil {
+		if retries > 0 && ctx.Err() == nil && !errors.Is(err, ErrSegmentTooLarge) {
+			return d.retrySegment(ctx, segment, retries, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Read data
+	data, err := io.ReadAll(body)
+	// A connection dropped mid-body can still end in a clean EOF, leaving a
+	// truncated segment; compare against the advertised length
+	if err == nil && resp.ContentLength >= 0 && resp.Header.Get("Content-Encoding") == "" &&
+		int64(len(data)) != resp.ContentLength {
+		err = fmt.Errorf("short read: got %d of %d bytes", len(data), resp.ContentLength)
+	}
+	err = checkBody(int64(len(data)), err)
+	if err != nil {
+		if retries > 0 && ctx.Err() == nil && !errors.Is(err, ErrSegmentTooLarge) {
+			return d.retrySegment(ctx, segment, retries, err)
+		}
+		return nil, err
+	}
+
+	// Server ignored the Range header, slice the requested range ourselves
+	if segment.ByteLength > 0 && resp.StatusCode == http.StatusOK {
+		end := segment.ByteStart + segment.ByteLength
+		if end > int64(len(data)) {
+			return nil, fmt.Errorf("byte range exceeds resource size %d", len(data))
+		}
+		data = data[segment.ByteStart:end]
+	}
+
+	// Decrypt if needed
+	if len(segment.Key) > 0 && len(segment.IV) > 0 {
+		decrypted, err := d.decryptAES128(data, segment.Key, segment.IV)
+		if err != nil {
+			err = fmt.Errorf("failed to decrypt: %w", err)
+			// Usually a truncated transfer, another attempt may get the whole segment
+			if retries > 0 {
+				return d.retrySegment(ctx, segment, retries, err)
+			}
+			return nil, err
+		}
+		data = decrypted
+
+		// A wrong key or IV still "decrypts", catch the garbage before it is merged
+		if !d.opts.SkipTSCheck && d.initSegment == nil && !looksLikeTS(data) {
+			err := errors.New("decrypted data is not MPEG-TS (wrong key or IV?)")
+			if retries > 0 {
+				return d.retrySegment(ctx, segment, retries, err)
+			}
+			return nil, err
+		}
+	}
+
+	if err := d.opts.Checksums.verify(segment, data); err != nil {
+		// A corrupt copy on one edge server may be fine on the next attempt
+		if retries > 0 {
+			return d.retrySegment(ctx, segment, retries, err)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Refine a body read result. Chunked responses carry no Content-Length to
+// compare against, but a stream closed before its last chunk fails with
+// io.ErrUnexpectedEOF, and an empty body is never a valid segment.
+func checkBody(size int64, err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("body cut off after %d bytes: %w", size, err)
+	}
+	if err == nil && size == 0 {
+		return errors.New("empty response body")
+	}
+	return err
+}
+
+// Wrap body in a decompressor matching a gzip or deflate Content-Encoding
+func decodeContent(body io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return reader, nil
+	case "deflate":
+		// "deflate" is meant to be zlib-wrapped, but some servers send raw deflate
+		buffered := bufio.NewReader(body)
+		header, err := buffered.Peek(2)
+		if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			reader, err := zlib.NewReader(buffered)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deflate body: %w", err)
+			}
+			return reader, nil
+		}
+		return flate.NewReader(buffered), nil
+	}
+	return body, nil
+}
+
+// Wait out the backoff, then fetch the segment again. lastErr is returned
+// instead when the wait would run past the segment's retry budget.
+func (d *Downloader) retrySegment(ctx context.Context, segment *Segment, retries int, lastErr error) ([]byte, error) {
+	delay := d.backoff(retries)
+	if err := d.checkRetryBudget(segment, delay, lastErr); err != nil {
+		return nil, err
+	}
+	if err := d.takeRetry(lastErr); err != nil {
+		return nil, err
+	}
+	d.logRetry(segment, retries, delay, lastErr)
+	if err := sleepContext(ctx, delay); err != nil {
+		return nil, err
+	}
+	data, err := d.fetchSegment(ctx, segment, retries-1)
+	return data, d.gaveUp(ctx, retries, err)
+}
+
+// Add the retry count to the error of the last attempt a segment gets
+func (d *Downloader) gaveUp(ctx context.Context, retries int, err error) error {
+	if err == nil || retries > 1 || ctx.Err() != nil {
+		return err
+	}
+	return fmt.Errorf("failed after %d retries: %w", d.opts.Retries, err)
+}
+
+// Run fetch until it succeeds, fails in a way it says isn't worth retrying,
+// or runs out of Retries, waiting out the same backoff as segments in between.
+// Used for playlists and keys, which a cold CDN cache may fail at first.
+func (d *Downloader) retryFetch(ctx context.Context, what, url string, fetch func() (bool, error)) error {
+	for retries := d.opts.Retries; ; retries-- {
+		retry, err := fetch()
+		if err == nil || !retry || retries <= 0 || ctx.Err() != nil {
+			return err
+		}
+		delay := d.backoff(retries)
+		if err := d.takeRetry(err); err != nil {
+			return err
+		}
+		d.logger.Warn("retrying "+what, "url", url, "attempt", d.opts.Retries-retries+2, "delay", delay, "error", err)
+		d.emit("warning", map[string]interface{}{"message": what + " request failed, retrying", "url": url, "error": err.Error()},
+			"⚠️  %s request failed (%v), retrying in %s\n", strings.ToUpper(what[:1])+what[1:], err, delay.Round(time.Millisecond))
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Whether a response status may clear up on its own: server errors, request
+// timeouts and rate limiting
+func transientStatus(code int) bool {
+	return code >= 500 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests
+}
+
+// Count a retry for Stats and against MaxTotalRetries, failing with
+// ErrRetryLimit once the whole download has used them up
+func (d *Downloader) takeRetry(lastErr error) error {
+	used := atomic.AddInt32(&d.retriesUsed, 1)
+	if d.opts.MaxTotalRetries > 0 && used > int32(d.opts.MaxTotalRetries) {
+		return fmt.Errorf("%w (%d): %v", ErrRetryLimit, d.opts.MaxTotalRetries, lastErr)
+	}
+	return nil
+}
+
+// Log a retry about to be made
+func (d *Downloader) logRetry(segment *Segment, retriesLeft int, delay time.Duration, err error) {
+	d.logger.Warn("retrying segment", "index", segment.Index, "attempt", d.opts.Retries-retriesLeft+2,
+		"delay", delay, "error", err)
+}
+
+// Delay before the next attempt, growing with the attempts already made and
+// capped at MaxBackoff. Jitter in [0.5, 1.5) keeps workers from retrying in
+// lockstep.
+func (d *Downloader) backoff(retriesLeft int) time.Duration {
+	attempt := d.opts.Retries - retriesLeft + 1
+	d.rngMu.Lock()
+	jitter := 0.5 + d.rng.Float64()
+	d.rngMu.Unlock()
+	delay := time.Duration(float64(attempt) * float64(d.opts.Backoff) * jitter)
+	if delay > d.opts.MaxBackoff {
+		delay = d.opts.MaxBackoff
+	}
+	return delay
+}
+
+// Fail fast with lastErr once waiting out delay would leave the segment's
+// retry budget, so one hopeless segment doesn't hold a worker
+func (d *Downloader) checkRetryBudget(segment *Segment, delay time.Duration, lastErr error) error {
+	if segment.retryDeadline.IsZero() || time.Now().Add(delay).Before(segment.retryDeadline) {
+		return nil
+	}
+	return fmt.Errorf("retry budget of %s used up: %w", d.opts.RetryBudget, lastErr)
+}
+
+// Download the fMP4 init segment referenced by EXT-X-MAP
+func (d *Downloader) downloadInit(ctx context.Context) error {
+	data, err := d.fetchSegment(ctx, d.initSegment, d.opts.Retries)
+	if err != nil {
+		return fmt.Errorf("failed to download init segment: %w", err)
+	}
+	return writeFileAtomic(filepath.Join(d.outputDir, "init.mp4"), data)
+}
+
+// Sleep for the given duration unless the context is cancelled first
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Download all segments concurrently
+func (d *Downloader) DownloadSegments(ctx context.Context) error {
+	d.emit("download_start", map[string]interface{}{"segments": len(d.segments), "workers": d.opts.Workers},
+		"\n🚀 Starting concurrent downloads...\n")
+	startTime := time.Now()
+	d.speed = speedMeter{start: startTime, lastTime: startTime}
+	defer func() { d.elapsed = time.Since(startTime) }()
+
+	if err := os.MkdirAll(d.outputDir, 0755); err != nil {
+		return err
+	}
+	// Widen segment names for huge playlists so they still sort in order
+	last := int64(len(d.segments) - 1)
+	if d.opts.SequenceNames && len(d.segments) > 0 {
+		last = d.segments[len(d.segments)-1].Sequence
+	}
+	if width := len(strconv.FormatInt(last, 10)); width > d.padWidth {
+		d.padWidth = width
+	}
+
+	if d.initSegment != nil {
+		if err := d.downloadInit(ctx); err != nil {
+			return err
+		}
+	}
+
+	if d.opts.PipelineMerge {
+		if err := d.startPipeline(ctx); err != nil {
+			return err
+		}
+	}
+
+	// Resume needs a fixed segment list on disk, which stream and live modes lack
+	if d.opts.Resume && !d.opts.Stream && !d.opts.Live {
+		d.manifest = d.loadManifest()
+		defer d.manifest.save()
+	}
+
+	// Create worker pool
+	semaphore := make(chan struct{}, d.opts.Workers)
+	for i := 0; i < d.opts.Workers; i++ {
+		semaphore <- struct{}{}
+	}
+
+	// Cap the whole run, since a server trickling bytes just fast enough never
+	// trips the per-request timeout
+	callerCtx := ctx
+	if d.opts.MaxDuration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, d.opts.MaxDuration)
+		defer cancelTimeout()
+	}
+
+	// Workers are cancelled when the download aborts or a stream gap appears
+	parentCtx := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// In stream mode a writer goroutine appends segments in order; window
+	// slots keep workers from running too far ahead of it, so a slow disk
+	// pauses dispatch instead of piling up segments in memory
+	var (
+		window   chan struct{}
+		streamCh chan error
+	)
+	if d.opts.Stream {
+		window = make(chan struct{}, cap(d.downloadedCh))
+		streamCh = make(chan error, 1)
+		go func() { streamCh <- d.streamSegments(window, cancel) }()
+	}
+
+	var wg sync.WaitGroup
+	aborted := int32(0)
+	retryLimited := int32(0)
+
+	// Live playlists are reloaded after each batch until EXT-X-ENDLIST
+	dispatched := 0
+dispatch:
+	for {
+		// Count repeats before any worker starts, so the first fetch knows
+		// to keep its data for them
+		if d.opts.Dedupe {
+			repeats := 0
+			for _, segment := range d.segments[dispatched:] {
+				if d.dedupe.add(segment) {
+					repeats++
+				}
+			}
+			if repeats > 0 {
+				d.emit("dedupe", map[string]interface{}{"segments": repeats},
+					"♻️  %d segments repeat earlier ones and will be fetched only once\n", repeats)
+			}
+		}
+		for _, segment := range d.segments[dispatched:] {
+			if window != nil {
+				select {
+				case window <- struct{}{}:
+				case <-ctx.Done():
+					break dispatch
+				}
+			}
+			dispatched++
+
+			wg.Add(1)
+			go func(seg *Segment) {
+				defer wg.Done()
+				select {
+				case <-semaphore:
+				case <-ctx.Done():
+					return
+				}
+				defer func() { semaphore <- struct{}{} }()
+
+				if ctx.Err() != nil {
+					return
+				}
+				started := time.Now()
+				err := d.downloadSegment(ctx, seg, d.opts.Retries)
+				if err != nil && len(d.mirrors) > 0 && ctx.Err() == nil && !errors.Is(err, ErrRetryLimit) {
+					err = d.failover(ctx, seg, err)
+				}
+				if err == nil {
+					d.recordSegmentTime(seg, time.Since(started))
+				}
+				if err != nil {
+					// Workers stopped by an abort or interrupt didn't really fail
+					if ctx.Err() != nil {
+						return
+					}
+					failed := d.recordFailure(seg, err)
+					if d.opts.Stream {
+						if d.opts.ContinueOnError {
+							// An empty segment lets the writer move past the gap
+							d.downloadedCh <- seg
+						} else {
+							// A gap can't be streamed past, stop the remaining workers
+							cancel()
+						}
+					}
+					// So many failures usually means a dead host, don't retry everything else
+					if d.opts.MaxErrors > 0 && failed > d.opts.MaxErrors {
+						atomic.StoreInt32(&aborted, 1)
+						cancel()
+					}
+					if errors.Is(err, ErrRetryLimit) {
+						atomic.StoreInt32(&retryLimited, 1)
+						atomic.StoreInt32(&aborted, 1)
+						cancel()
+					}
+				}
+			}(segment)
+		}
+
+		if !d.opts.Live || d.finalPlaylist() {
+			break
+		}
+		if err := sleepContext(ctx, d.reloadInterval()); err != nil {
+			break
+		}
+		if err := d.reloadPlaylist(ctx); err != nil {
+			d.emit("warning", map[string]interface{}{"message": err.Error()}, "\n⚠️  Failed to reload live playlist: %v\n", err)
+		}
+	}
+
+	wg.Wait()
+	close(d.downloadedCh)
+
+	if streamCh != nil {
+		if err := <-streamCh; err != nil {
+			return err
+		}
+	}
+
+	if err := parentCtx.Err(); err != nil {
+		if callerCtx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("download exceeded max duration of %s with %d of %d segments done: %w",
+				d.opts.MaxDuration, atomic.LoadInt32(&d.progress), len(d.segments), err)
+		}
+		return fmt.Errorf("download cancelled: %w", err)
+	}
+
+	if len(d.segments) == 0 {
+		return fmt.Errorf("live stream ended without any segments")
+	}
+
+	if failed := d.sortedFailures(); len(failed) > 0 {
+		aborted := atomic.LoadInt32(&aborted) == 1
+		if aborted || !d.opts.ContinueOnError {
+			downloadErr := &DownloadError{Failed: failed, Aborted: aborted}
+			if atomic.LoadInt32(&retryLimited) == 1 {
+				downloadErr.Reason = fmt.Sprintf("all %d retries allowed by the total retry limit were used", d.opts.MaxTotalRetries)
+			}
+			return downloadErr
+		}
+		indices := make([]int, len(failed))
+		for i, f := range failed {
+			indices[i] = f.Index
+		}
+		d.emit("warning", map[string]interface{}{"message": "segments failed", "segments": indices},
+			"\n⚠️  %d segments failed and will be missing from the output: %v\n", len(failed), indices)
+	}
+
+	duration := time.Since(startTime)
+	d.emit("download_complete", map[string]interface{}{"seconds": duration.Seconds(), "bytes": atomic.LoadInt64(&d.totalSize)},
+		"\n✅ All segments downloaded in %.2fs\n", duration.Seconds())
+
+	return nil
+}
+
+// Merge all segments into output file
+func (d *Downloader) MergeSegments() error {
+	// Streaming already wrote the output during download
+	if d.opts.Stream {
+		return nil
+	}
+
+	if d.opts.KeepSegments {
+		return d.keepSegments()
+	}
+	if d.pipeline != nil {
+		return d.finishPipeline()
+	}
+
+	d.emit("merge_start", nil, "🔗 Merging segments...\n")
+
+	outFile, err := d.createOutput()
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	writer := bufio.NewWriter(outFile)
+	defer writer.Flush()
+
+	// fMP4 streams need the init segment ahead of any media data
+	if err := d.writeInit(writer); err != nil {
+		return err
+	}
+
+	var written int64
+	for i := 0; i < len(d.segments); i++ {
+		if d.segments[i].Discontinuity {
+			d.boundaries = append(d.boundaries, written)
+		}
+		segmentFile := d.segmentPath(d.segments[i])
+		file, err := os.Open(segmentFile)
+		if err != nil {
+			// Failed segments are left out when continuing past errors
+			if d.opts.ContinueOnError && d.segmentFailed(i) {
+				continue
+			}
+			return fmt.Errorf("failed to open segment %d: %w", i, err)
+		}
+
+		n, err := io.Copy(writer, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		written += n
+
+		// Clean up segment file
+		if !d.opts.KeepTemp {
+			os.Remove(segmentFile)
+		}
+	}
+
+	d.emit("merged", map[string]interface{}{"output": d.outputFile}, "✅ Merged into: %s\n", d.outputFile)
+	return nil
+}
+
+// Path of the temp file holding a downloaded segment
+func (d *Downloader) segmentPath(segment *Segment) string {
+	number := int64(segment.Index)
+	if d.opts.SequenceNames {
+		number = segment.Sequence
+	}
+	ext := segment.Ext
+	if ext == "" {
+		ext = ".ts"
+	}
+	return filepath.Join(d.outputDir, fmt.Sprintf("segment_%0*d%s", d.padWidth, number, ext))
+}
+
+// Write data to a .part file and rename it into place once synced, so a crash
+// never leaves a truncated file under the final name
+func writeFileAtomic(path string, data []byte) error {
+	_, err := copyFileAtomic(path, bytes.NewReader(data))
+	return err
+}
+
+// Like writeFileAtomic, but copying from r and returning the bytes written
+func copyFileAtomic(path string, r io.Reader) (int64, error) {
+	partPath := path + ".part"
+	file, err := os.Create(partPath)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(file, r)
+	if err != nil {
+		file.Close()
+		os.Remove(partPath)
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(partPath)
+		return 0, err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partPath)
+		return 0, err
+	}
+	return n, os.Rename(partPath, path)
+}
+
+// Copy the downloaded init segment, if any, to the head of the output
+func (d *Downloader) writeInit(w io.Writer) error {
+	if d.initSegment == nil {
+		return nil
+	}
+
+	initFile := filepath.Join(d.outputDir, "init.mp4")
+	file, err := os.Open(initFile)
+	if err != nil {
+		return fmt.Errorf("failed to open init segment: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(w, file); err != nil {
+		return err
+	}
+	if !d.opts.KeepTemp {
+		os.Remove(initFile)
+	}
+	return nil
+}
+
+// Cleanup temporary directory
+func (d *Downloader) Cleanup() {
+	d.discardPipeline()
+	os.RemoveAll(d.outputDir)
+}
@@ -0,0 +1,298 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSegmentRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    int32 // 503 responses before the second segment is served
+		retries     int
+		wantRetries int
+		wantErr     string
+	}{
+		{"first attempt", 0, 2, 0, ""},
+		{"recovers", 2, 2, 2, ""},
+		{"gives up", 3, 2, 2, "failed after 2 retries"},
+		{"retries disabled", 1, -1, 0, "status 503"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			server := newTestServer(t, map[string]http.HandlerFunc{
+				// The container probe reads the first segment, so the second one fails
+				"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"),
+				"/0.ts":       serveString("Gfirst|"),
+				"/1.ts": func(w http.ResponseWriter, r *http.Request) {
+					if atomic.AddInt32(&attempts, 1) <= tt.failures {
+						http.Error(w, "unavailable", http.StatusServiceUnavailable)
+						return
+					}
+					w.Write([]byte("Gsecond"))
+				},
+			})
+			got, stats, err := downloadString(t, Options{
+				URL:       server.URL + "/video.m3u8",
+				Retries:   tt.retries,
+				Backoff:   time.Millisecond,
+				MaxErrors: 1,
+			})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if got != "Gfirst|Gsecond" {
+				t.Errorf("output = %q", got)
+			}
+			if stats.Retries != tt.wantRetries {
+				t.Errorf("Stats.Retries = %d, want %d", stats.Retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestDecodeContent(t *testing.T) {
+	const data = "Gpayload"
+	compress := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var buf bytes.Buffer
+		w := newWriter(&buf)
+		w.Write([]byte(data))
+		w.Close()
+		return buf.Bytes()
+	}
+	gzipped := compress(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	zlibbed := compress(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	raw := compress(func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+
+	tests := []struct {
+		encoding string
+		body     []byte
+		wantErr  bool
+	}{
+		{"", []byte(data), false},
+		{"identity", []byte(data), false},
+		{"gzip", gzipped, false},
+		{" X-GZIP ", gzipped, false},
+		{"deflate", zlibbed, false},
+		{"deflate", raw, false},
+		{"gzip", []byte(data), true},
+	}
+	for _, tt := range tests {
+		reader, err := decodeContent(bytes.NewReader(tt.body), tt.encoding)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("decodeContent(%q) accepted an invalid body", tt.encoding)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeContent(%q): %v", tt.encoding, err)
+			continue
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil || string(got) != data {
+			t.Errorf("decodeContent(%q) = %q, %v; want %q", tt.encoding, got, err, data)
+		}
+	}
+}
+
+func TestGzipSegment(t *testing.T) {
+	compressed := gzipBytes("Gcompressed")
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+		"/0.ts": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed)
+		},
+	})
+	for _, lowMemory := range []bool{false, true} {
+		// Asking for gzip ourselves stops Go from decompressing transparently
+		got, _, err := downloadString(t, Options{
+			URL:       server.URL + "/video.m3u8",
+			Headers:   http.Header{"Accept-Encoding": {"gzip"}},
+			LowMemory: lowMemory,
+		})
+		if err != nil {
+			t.Fatalf("LowMemory=%v: %v", lowMemory, err)
+		}
+		if got != "Gcompressed" {
+			t.Errorf("LowMemory=%v: output = %q, want the decompressed segment", lowMemory, got)
+		}
+	}
+}
+
+func TestSegmentCutOffIsRetried(t *testing.T) {
+	for _, lowMemory := range []bool{false, true} {
+		var attempts int32
+		server := newTestServer(t, map[string]http.HandlerFunc{
+			"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"),
+			"/0.ts":       serveString("Gfirst|"),
+			"/1.ts": func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&attempts, 1) > 1 {
+					w.Write([]byte("Gsecond"))
+					return
+				}
+				// Promise the whole segment, send part of it and hang up
+				conn, buf, err := w.(http.Hijacker).Hijack()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 7\r\n\r\nGse")
+				buf.Flush()
+				conn.Close()
+			},
+		})
+		got, stats, err := downloadString(t, Options{
+			URL:       server.URL + "/video.m3u8",
+			Backoff:   time.Millisecond,
+			LowMemory: lowMemory,
+		})
+		if err != nil {
+			t.Fatalf("LowMemory=%v: %v", lowMemory, err)
+		}
+		if got != "Gfirst|Gsecond" {
+			t.Errorf("LowMemory=%v: output = %q", lowMemory, got)
+		}
+		if stats.Retries != 1 {
+			t.Errorf("LowMemory=%v: Stats.Retries = %d, want 1", lowMemory, stats.Retries)
+		}
+	}
+}
+
+func TestCheckBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    int64
+		err     error
+		wantErr string
+	}{
+		{"complete", 10, nil, ""},
+		{"empty", 0, nil, "empty response body"},
+		{"cut off", 4, io.ErrUnexpectedEOF, "body cut off after 4 bytes"},
+		{"other error", 4, errors.New("reset by peer"), "reset by peer"},
+	}
+	for _, tt := range tests {
+		err := checkBody(tt.size, tt.err)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: checkBody = %v", tt.name, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: checkBody = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestChunkedSegment(t *testing.T) {
+	for _, lowMemory := range []bool{false, true} {
+		var attempts int32
+		server := newTestServer(t, map[string]http.HandlerFunc{
+			"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"),
+			"/0.ts":       serveString("Gfirst|"),
+			"/1.ts": func(w http.ResponseWriter, r *http.Request) {
+				switch atomic.AddInt32(&attempts, 1) {
+				case 1:
+					// Chunked and empty
+					w.(http.Flusher).Flush()
+				case 2:
+					// Chunked and closed before the terminating chunk
+					conn, buf, _ := w.(http.Hijacker).Hijack()
+					buf.WriteString("HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n3\r\nGse\r\n")
+					buf.Flush()
+					conn.Close()
+				default:
+					for _, part := range []string{"Gse", "co", "nd"} {
+						w.Write([]byte(part))
+						w.(http.Flusher).Flush()
+					}
+				}
+			},
+		})
+		got, stats, err := downloadString(t, Options{
+			URL:       server.URL + "/video.m3u8",
+			Backoff:   time.Millisecond,
+			LowMemory: lowMemory,
+		})
+		if err != nil {
+			t.Fatalf("LowMemory=%v: %v", lowMemory, err)
+		}
+		if got != "Gfirst|Gsecond" {
+			t.Errorf("LowMemory=%v: output = %q", lowMemory, got)
+		}
+		if stats.Retries != 2 {
+			t.Errorf("LowMemory=%v: Stats.Retries = %d, want 2", lowMemory, stats.Retries)
+		}
+	}
+}
+
+func TestRetriesDisabledForAudioTrack(t *testing.T) {
+	var attempts int32
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=\"Main\",DEFAULT=YES,URI=\"audio.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"aud\"\nvideo.m3u8\n"),
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\nvideo.ts\n#EXT-X-ENDLIST\n"),
+		"/audio.m3u8": serveString("#EXTM3U\n#EXTINF:1,\naudio.ts\n#EXT-X-ENDLIST\n"),
+		"/video.ts":   serveString("Gvideo"),
+		"/audio.ts": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&attempts, 1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+	})
+	_, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8", Retries: -1, Backoff: time.Millisecond})
+	if err == nil {
+		t.Fatal("download succeeded without the audio track")
+	}
+	// The audio container probe reads the segment once before the download
+	if attempts > 2 {
+		t.Errorf("audio segment requested %d times with retries disabled", attempts)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	opts := Options{
+		Retries:    10,
+		Backoff:    100 * time.Millisecond,
+		MaxBackoff: 350 * time.Millisecond,
+		Seed:       42,
+		Events:     discardWriter{},
+	}
+	tests := []struct {
+		retriesLeft int
+		min, max    time.Duration // Jitter keeps the delay in [min, max), or at max once capped
+	}{
+		{10, 50 * time.Millisecond, 150 * time.Millisecond},
+		{9, 100 * time.Millisecond, 300 * time.Millisecond},
+		{8, 150 * time.Millisecond, 350 * time.Millisecond},
+		{2, 350 * time.Millisecond, 350 * time.Millisecond}, // Even the lowest jitter is over MaxBackoff
+	}
+	d, same := NewDownloader(opts), NewDownloader(opts)
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			got := d.backoff(tt.retriesLeft)
+			if got < tt.min || got > tt.max || (got == tt.max && tt.max != opts.MaxBackoff) {
+				t.Fatalf("backoff(%d) = %v, want in [%v, %v)", tt.retriesLeft, got, tt.min, tt.max)
+			}
+			if again := same.backoff(tt.retriesLeft); again != got {
+				t.Fatalf("backoff(%d) = %v and %v with the same seed", tt.retriesLeft, got, again)
+			}
+		}
+	}
+}
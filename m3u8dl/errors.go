@@ -0,0 +1,100 @@
+package m3u8dl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrAborted is returned when too many segments failed and the download stopped early
+var ErrAborted = errors.New("download aborted early")
+
+// ErrRetryLimit fails the segment that would exceed Options.MaxTotalRetries
+var ErrRetryLimit = errors.New("total retry limit reached")
+
+// ErrOutputExists is returned when the output file has data and Options.Force isn't set
+var ErrOutputExists = errors.New("output file already exists")
+
+// ErrSegmentTooLarge fails a segment bigger than Options.MaxSegmentSize; it
+// isn't retried
+var ErrSegmentTooLarge = errors.New("segment exceeds the size limit")
+
+// maxListedFailures caps how many segment errors DownloadError spells out
+const maxListedFailures = 10
+
+// SegmentError records why a single segment could not be downloaded
+type SegmentError struct {
+	Index int
+	URL   string
+	Err   error
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("segment %d: %v", e.Index, e.Err)
+}
+
+func (e *SegmentError) Unwrap() error {
+	return e.Err
+}
+
+// DownloadError aggregates every segment that failed during a download
+type DownloadError struct {
+	Failed  []*SegmentError // Sorted by segment index
+	Aborted bool            // The failure limit was hit and remaining segments were skipped
+	Reason  string          // What stopped an aborted download, if not the failure limit
+}
+
+func (e *DownloadError) Error() string {
+	var b strings.Builder
+	if e.Aborted && e.Reason != "" {
+		fmt.Fprintf(&b, "%v (%s) after %d failed segments", ErrAborted, e.Reason, len(e.Failed))
+	} else if e.Aborted {
+		fmt.Fprintf(&b, "%v after %d failed segments", ErrAborted, len(e.Failed))
+	} else {
+		fmt.Fprintf(&b, "encountered %d errors during download", len(e.Failed))
+	}
+	for i, f := range e.Failed {
+		if i == maxListedFailures {
+			fmt.Fprintf(&b, "\n  ... and %d more", len(e.Failed)-i)
+			break
+		}
+		fmt.Fprintf(&b, "\n  %v", f)
+	}
+	return b.String()
+}
+
+// Is lets errors.Is(err, ErrAborted) match an aborted download
+func (e *DownloadError) Is(target error) bool {
+	return e.Aborted && target == ErrAborted
+}
+
+// Record a failed segment, returning how many have failed so far
+func (d *Downloader) recordFailure(segment *Segment, err error) int {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	d.failures = append(d.failures, &SegmentError{Index: segment.Index, URL: segment.URL, Err: err})
+	d.logger.Error("segment failed", "index", segment.Index, "url", segment.URL, "error", err)
+	return len(d.failures)
+}
+
+// Whether a segment is known to have failed
+func (d *Downloader) segmentFailed(index int) bool {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	for _, f := range d.failures {
+		if f.Index == index {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot of the failures sorted by segment index
+func (d *Downloader) sortedFailures() []*SegmentError {
+	d.failMu.Lock()
+	defer d.failMu.Unlock()
+	failed := append([]*SegmentError(nil), d.failures...)
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Index < failed[j].Index })
+	return failed
+}
@@ -0,0 +1,97 @@
+package m3u8dl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Event is a status update emitted while downloading. Message is the
+// human-readable line; Fields carry the same information for machines.
+type Event struct {
+	Name    string
+	Message string
+	Fields  map[string]interface{}
+}
+
+// EventWriter receives every status update. Emit may be called
+// concurrently from download workers.
+type EventWriter interface {
+	Emit(Event)
+}
+
+// NewTextWriter prints the human-readable message of each event
+func NewTextWriter(w io.Writer) EventWriter {
+	return &textWriter{w: w}
+}
+
+type textWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (t *textWriter) Emit(e Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprint(t.w, e.Message)
+}
+
+// NewJSONWriter writes each event as one line of JSON, e.g.
+// {"event":"progress","bytes":1048576,"done":12,"speed":524288,"total":400}
+func NewJSONWriter(w io.Writer) EventWriter {
+	return &jsonWriter{w: w}
+}
+
+type jsonWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonWriter) Emit(e Event) {
+	name, err := json.Marshal(e.Name)
+	if err != nil {
+		return
+	}
+	line := append([]byte(`{"event":`), name...)
+
+	// Keep "event" as the first key, followed by the sorted fields
+	if len(e.Fields) > 0 {
+		fields, err := json.Marshal(e.Fields)
+		if err != nil {
+			return
+		}
+		line = append(append(line, ','), fields[1:]...)
+	} else {
+		line = append(line, '}')
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.w.Write(append(line, '\n'))
+}
+
+// Quiet wraps w so only "error" and "complete" events get through
+func Quiet(w EventWriter) EventWriter {
+	return quietWriter{next: w}
+}
+
+type quietWriter struct {
+	next EventWriter
+}
+
+func (q quietWriter) Emit(e Event) {
+	if e.Name == "error" || e.Name == "complete" {
+		q.next.Emit(e)
+	}
+}
+
+// Drops every event, for internal helpers whose output would only confuse
+type discardWriter struct{}
+
+func (discardWriter) Emit(Event) {}
+
+// Emit an event with a printf-style human message
+func (d *Downloader) emit(name string, fields map[string]interface{}, format string, args ...interface{}) {
+	d.events.Emit(Event{Name: name, Message: fmt.Sprintf(format, args...), Fields: fields})
+}
@@ -0,0 +1,112 @@
+package m3u8dl
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+)
+
+// Largest difference in EXTINF durations still taken as the same segment
+const mirrorDurationSlack = 0.5
+
+// Variants that repeat the chosen one's bandwidth, resolution and codecs
+// under another URL, which is how masters list redundant streams
+func redundantVariants(variants []Variant, chosen Variant) []string {
+	var urls []string
+	for _, v := range variants {
+		if v.URL != chosen.URL && v.Bandwidth == chosen.Bandwidth && v.Width == chosen.Width &&
+			v.Height == chosen.Height && v.Codecs == chosen.Codecs {
+			urls = append(urls, v.URL)
+		}
+	}
+	return urls
+}
+
+// Parse each redundant variant's media playlist and keep those that line up
+// with the primary one segment for segment
+func (d *Downloader) loadMirrors(ctx context.Context) {
+	for _, mirrorURL := range d.mirrorURLs {
+		segments, err := d.mirrorSegments(ctx, mirrorURL)
+		if err != nil {
+			d.emit("warning", map[string]interface{}{"message": err.Error(), "url": mirrorURL},
+				"⚠️  Mirror %s is not usable for failover: %v\n", mirrorHost(mirrorURL), err)
+			continue
+		}
+		d.mirrors = append(d.mirrors, segments)
+		d.emit("mirror", map[string]interface{}{"url": mirrorURL},
+			"🪞 Failover mirror: %s\n", mirrorHost(mirrorURL))
+	}
+}
+
+// Segments of a mirror by media sequence number, or an error when its
+// playlist isn't structurally parallel to the primary one
+func (d *Downloader) mirrorSegments(ctx context.Context, mirrorURL string) (map[int64]*Segment, error) {
+	opts := d.childOptions()
+	opts.Events = discardWriter{}
+	mirror := NewDownloader(opts)
+	mirror.client = d.client
+	mirror.keyCache = d.keyCache
+	mirror.m3u8URL = mirrorURL
+
+	content, err := mirror.fetchPlaylist(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(content, "#EXT-X-STREAM-INF") {
+		return nil, fmt.Errorf("it is another master playlist")
+	}
+	if err := mirror.parseMediaPlaylist(ctx, content); err != nil {
+		return nil, err
+	}
+
+	bySequence := make(map[int64]*Segment, len(mirror.segments))
+	for _, segment := range mirror.segments {
+		bySequence[segment.Sequence] = segment
+	}
+	// Segments are matched by media sequence number, so both playlists need
+	// the same numbering and durations
+	for _, segment := range d.segments {
+		alt, ok := bySequence[segment.Sequence]
+		if !ok {
+			return nil, fmt.Errorf("it has no segment with media sequence %d", segment.Sequence)
+		}
+		if math.Abs(alt.Duration-segment.Duration) > mirrorDurationSlack {
+			return nil, fmt.Errorf("segment %d lasts %.3fs there but %.3fs on the primary",
+				segment.Index, alt.Duration, segment.Duration)
+		}
+	}
+	return bySequence, nil
+}
+
+// Retry a segment that failed on the primary from each mirror in turn
+func (d *Downloader) failover(ctx context.Context, segment *Segment, err error) error {
+	for _, mirror := range d.mirrors {
+		alt, ok := mirror[segment.Sequence]
+		if !ok {
+			continue
+		}
+		// Stand in for the primary segment, keeping its place in the output
+		standIn := *alt
+		standIn.Index = segment.Index
+		standIn.Ext = segment.Ext
+		standIn.Discontinuity = segment.Discontinuity
+		standIn.mirror = true
+
+		d.emit("failover", map[string]interface{}{"index": segment.Index, "url": standIn.URL, "error": err.Error()},
+			"\n🔀 Segment %d failed (%v), trying %s\n", segment.Index, err, mirrorHost(standIn.URL))
+		if err = d.downloadSegment(ctx, &standIn, d.opts.Retries); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// Host of a mirror URL for messages
+func mirrorHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return rawURL
+}
@@ -0,0 +1,17 @@
+package m3u8dl
+
+// SkipAdBreaks is a Filter for streams that splice ad breaks into the
+// programme between EXT-X-DISCONTINUITY tags, one before the break and one
+// after it. It keeps the segments with an even DiscontinuitySequence, so it
+// assumes the stream began with programme content and that breaks hold no
+// discontinuities of their own, e.g. one per ad. Live windows stay in step
+// through EXT-X-DISCONTINUITY-SEQUENCE.
+func SkipAdBreaks(segment *Segment) bool {
+	return segment.DiscontinuitySequence%2 == 0
+}
+
+// Report segments Options.Filter left out
+func (d *Downloader) emitFiltered(count int) {
+	d.emit("filtered", map[string]interface{}{"segments": count, "total": d.filtered},
+		"🚫 Filter left out %d segments\n", count)
+}
@@ -0,0 +1,99 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Key served by the encrypted test playlists
+var testKey = []byte("0123456789abcdef")
+
+// Encrypt data with AES-128-CBC and PKCS7 padding, as a packager would
+func encryptSegment(t *testing.T, data, key, iv []byte) []byte {
+	t.Helper()
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	padded := append(append([]byte(nil), data...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	out := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, padded)
+	return out
+}
+
+// Compress data with gzip, as an origin with compression enabled would
+func gzipBytes(data string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(data))
+	gz.Close()
+	return buf.Bytes()
+}
+
+// Serve each path with its handler, closing the server when the test ends
+func newTestServer(t *testing.T, handlers map[string]http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, handler := range handlers {
+		mux.HandleFunc(path, handler)
+	}
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Handler that always responds with body
+func serveString(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}
+}
+
+// Parse the playlist at url without downloading any segments
+func parseURL(t *testing.T, url string) *Downloader {
+	t.Helper()
+	d := NewDownloader(Options{
+		URL:        url,
+		OutputFile: filepath.Join(t.TempDir(), "out.ts"),
+		Events:     discardWriter{},
+	})
+	if err := d.ParseM3U8(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// Handler that serves body with Content-Length, HEAD and Range support
+func serveContent(body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	}
+}
+
+// Run Download into a temp directory without status output, returning what
+// was written to the output file
+func downloadString(t *testing.T, opts Options) (string, Stats, error) {
+	t.Helper()
+	dir := t.TempDir()
+	if opts.OutputFile == "" {
+		opts.OutputFile = filepath.Join(dir, "out.ts")
+	}
+	opts.TempDir = filepath.Join(dir, "temp")
+	if opts.Events == nil {
+		opts.Events = discardWriter{}
+	}
+	stats, err := Download(context.Background(), opts)
+	data, _ := os.ReadFile(opts.OutputFile)
+	return string(data), stats, err
+}
@@ -0,0 +1,57 @@
+package m3u8dl
+
+import (
+	"context"
+	"time"
+)
+
+// Reload interval used when the playlist doesn't declare a target duration
+const defaultReloadInterval = 5 * time.Second
+
+// Wait between live playlist reloads, derived from EXT-X-TARGETDURATION:
+// a full target duration after new segments appeared, half of it after a
+// reload that brought nothing new, as the HLS spec recommends
+func (d *Downloader) reloadInterval() time.Duration {
+	interval := defaultReloadInterval
+	if d.targetDuration > 0 {
+		interval = d.targetDuration
+	}
+	if d.reloadStale {
+		interval /= 2
+	}
+	return interval
+}
+
+// Whether the segment list can't change anymore: ENDLIST was seen, or the
+// playlist declared itself VOD
+func (d *Downloader) finalPlaylist() bool {
+	return d.endList || d.playlistType == "VOD"
+}
+
+// Re-fetch a live playlist and append segments that appeared since the last load
+func (d *Downloader) reloadPlaylist(ctx context.Context) error {
+	// A failed reload isn't retried here; the next one stands in for it
+	content, _, err := d.loadPlaylist(ctx)
+	if err != nil {
+		return err
+	}
+
+	before, filteredBefore := len(d.segments), d.filtered
+	if err := d.parseMediaPlaylist(ctx, content); err != nil {
+		return err
+	}
+
+	added, filtered := len(d.segments)-before, d.filtered-filteredBefore
+	d.reloadStale = added == 0 && filtered == 0
+	if filtered > 0 {
+		d.emitFiltered(filtered)
+	}
+	if added > 0 {
+		d.emit("live_reload", map[string]interface{}{"added": added, "total": len(d.segments)},
+			"\n📡 Live: %d new segments (%d total)\n", added, len(d.segments))
+	}
+	if d.finalPlaylist() {
+		d.emit("live_end", nil, "\n🏁 Live stream ended\n")
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalPlaylistName is the playlist written next to kept segments
+const LocalPlaylistName = "local.m3u8"
+
+// ConcatListName is the ffmpeg concat demuxer list written with ConcatList
+const ConcatListName = "concat.txt"
+
+// Move the downloaded segments into a directory named after the output and
+// write a media playlist that plays them, instead of merging them
+func (d *Downloader) keepSegments() error {
+	dir := strings.TrimSuffix(d.outputFile, filepath.Ext(d.outputFile))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	var (
+		body        strings.Builder
+		concat      strings.Builder
+		maxDuration float64
+		version     = 3
+	)
+	if d.initSegment != nil {
+		if err := moveFile(filepath.Join(d.outputDir, "init.mp4"), filepath.Join(dir, "init.mp4")); err != nil {
+			return fmt.Errorf("failed to keep init segment: %w", err)
+		}
+		// EXT-X-MAP without encryption needs version 6
+		version = 6
+		body.WriteString("#EXT-X-MAP:URI=\"init.mp4\"\n")
+	}
+
+	for _, segment := range d.segments {
+		src := d.segmentPath(segment)
+		name := filepath.Base(src)
+		if err := moveFile(src, filepath.Join(dir, name)); err != nil {
+			// Failed segments are left out when continuing past errors
+			if d.opts.ContinueOnError && d.segmentFailed(segment.Index) {
+				continue
+			}
+			return fmt.Errorf("failed to keep segment %d: %w", segment.Index, err)
+		}
+		if segment.Discontinuity {
+			body.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		// Segments are stored decrypted, so no EXT-X-KEY is written
+		fmt.Fprintf(&body, "#EXTINF:%.3f,\n%s\n", segment.Duration, name)
+		fmt.Fprintf(&concat, "file '%s'\n", name)
+		maxDuration = math.Max(maxDuration, segment.Duration)
+	}
+
+	path := filepath.Join(dir, LocalPlaylistName)
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	fmt.Fprintf(writer, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n",
+		version, int(math.Ceil(maxDuration)))
+	if len(d.segments) > 0 {
+		fmt.Fprintf(writer, "#EXT-X-MEDIA-SEQUENCE:%d\n", d.segments[0].Sequence)
+	}
+	writer.WriteString(body.String())
+	writer.WriteString("#EXT-X-ENDLIST\n")
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	d.outputFile = path
+	d.emit("segments_kept", map[string]interface{}{"dir": dir, "playlist": path},
+		"✅ Segments kept in %s, play them with %s\n", dir, path)
+
+	if d.opts.ConcatList {
+		return d.writeSegmentList(dir, concat.String())
+	}
+	return nil
+}
+
+// Write the ffmpeg concat list for the kept segments. The concat demuxer
+// corrects timestamps per file, which a byte merge of streams with
+// discontinuities can't.
+func (d *Downloader) writeSegmentList(dir, list string) error {
+	// fMP4 fragments can't be read without the init segment in front
+	if d.initSegment != nil {
+		d.emit("warning", map[string]interface{}{"message": "concat list doesn't support fMP4 segments"},
+			"⚠️  fMP4 segments can't be joined with ffmpeg's concat demuxer, use %s instead\n", d.outputFile)
+		return nil
+	}
+	path := filepath.Join(dir, ConcatListName)
+	if err := os.WriteFile(path, []byte(list), 0644); err != nil {
+		return err
+	}
+	d.outputFile = path
+	d.emit("concat_list", map[string]interface{}{"list": path, "command": d.ConcatCommand()},
+		"📝 Concat list written to %s\n", path)
+	return nil
+}
+
+// ConcatCommand is the ffmpeg command that muxes the segments of a concat
+// list into an MP4 next to their directory
+func (d *Downloader) ConcatCommand() string {
+	dir := filepath.Dir(d.outputFile)
+	return fmt.Sprintf("ffmpeg -f concat -safe 0 -i %s -c copy %s", ShellQuote(d.outputFile), ShellQuote(dir+".mp4"))
+}
+
+// ShellQuote single-quotes a path for a POSIX shell so a suggested command
+// still works when pasted; paths without special characters are left as is
+func ShellQuote(s string) string {
+	plain := s != ""
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@%+=,", r)) {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Rename a file, copying it when src and dst are on different filesystems
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	return os.Remove(src)
+}
@@ -0,0 +1,47 @@
+package m3u8dl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Drops every record, the logger used when Options.Logger is nil
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// ParseLogLevel parses "debug", "info", "warn" or "error"
+func ParseLogLevel(value string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q, expected debug, info, warn or error", value)
+}
+
+// Log each redirect the client follows, stopping after 10 like the default.
+// Only HTTP(S) targets are followed, so a server can't point at a local file
+// when the file transport is registered.
+func (d *Downloader) logRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect to %s", req.URL)
+	}
+	d.logger.Debug("redirect", "from", via[len(via)-1].URL.String(), "to", req.URL.String())
+	return nil
+}
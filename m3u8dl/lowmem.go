@@ -0,0 +1,143 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+)
+
+// Stream a segment from the response into its file, decrypting on the fly,
+// so a worker holds only a small buffer instead of the whole segment.
+// Returns the number of bytes written.
+func (d *Downloader) saveSegment(ctx context.Context, segment *Segment, retries int) (int64, error) {
+	resp, body, err := d.openSegment(ctx, segment)
+	if err != nil {
+		return d.saveFailed(ctx, segment, retries, err)
+	}
+	defer resp.Body.Close()
+
+	// Count what arrives so a clean EOF on a dropped connection is caught
+	counter := &countingReader{r: body}
+	var reader io.Reader = counter
+	want := int64(-1)
+	if resp.Header.Get("Content-Encoding") == "" {
+		want = resp.ContentLength
+	}
+	// Server ignored the Range header, skip to the requested range ourselves
+	if segment.ByteLength > 0 && resp.StatusCode == http.StatusOK {
+		if _, err := io.CopyN(io.Discard, counter, segment.ByteStart); err != nil {
+			// A clean EOF may also be a dropped connection, so it is retried too
+			if err == io.EOF {
+				err = fmt.Errorf("byte range exceeds resource size %d", counter.n)
+			}
+			return d.saveFailed(ctx, segment, retries, checkBody(counter.n, err))
+		}
+		reader = io.LimitReader(counter, segment.ByteLength)
+		want = segment.ByteStart + segment.ByteLength
+	}
+
+	encrypted := len(segment.Key) > 0 && len(segment.IV) > 0
+	if encrypted {
+		if reader, err = d.newCBCReader(reader, segment.Key, segment.IV); err != nil {
+			return 0, fmt.Errorf("failed to decrypt: %w", err)
+		}
+	}
+
+	// A wrong key or IV still "decrypts", catch the garbage before it is merged
+	buffered := bufio.NewReader(reader)
+	reader = buffered
+	if encrypted && !d.opts.SkipTSCheck && d.initSegment == nil {
+		head, err := buffered.Peek(tsPacketSize + 1)
+		if err != nil && err != io.EOF {
+			return d.saveFailed(ctx, segment, retries, err)
+		}
+		if !looksLikeTS(head) {
+			return d.saveFailed(ctx, segment, retries, errors.New("decrypted data is not MPEG-TS (wrong key or IV?)"))
+		}
+	}
+
+	var sum hash.Hash
+	if d.opts.Checksums != nil {
+		sum = sha256.New()
+		reader = io.TeeReader(reader, sum)
+	}
+
+	// Checks that need the whole body run at EOF, failing the copy so the
+	// incomplete file is never renamed into place
+	reader = &eofCheckReader{r: reader, check: func() error {
+		if want >= 0 && counter.n != want {
+			return fmt.Errorf("short read: got %d of %d bytes", counter.n, want)
+		}
+		if err := checkBody(counter.n, nil); err != nil {
+			return err
+		}
+		if sum != nil {
+			return d.opts.Checksums.verifySum(segment, sum.Sum(nil))
+		}
+		return nil
+	}}
+
+	size, err := copyFileAtomic(d.segmentPath(segment), reader)
+	if err != nil {
+		return d.saveFailed(ctx, segment, retries, checkBody(counter.n, err))
+	}
+	return size, nil
+}
+
+// Retry a failed streamed save if attempts remain, else return err
+func (d *Downloader) saveFailed(ctx context.Context, segment *Segment, retries int, err error) (int64, error) {
+	if retries > 0 && ctx.Err() == nil && !errors.Is(err, ErrSegmentTooLarge) {
+		return d.retrySave(ctx, segment, retries, err)
+	}
+	return 0, err
+}
+
+// Wait out the backoff, then stream the segment again
+func (d *Downloader) retrySave(ctx context.Context, segment *Segment, retries int, lastErr error) (int64, error) {
+	delay := d.backoff(retries)
+	if err := d.checkRetryBudget(segment, delay, lastErr); err != nil {
+		return 0, err
+	}
+	if err := d.takeRetry(lastErr); err != nil {
+		return 0, err
+	}
+	d.logRetry(segment, retries, delay, lastErr)
+	if err := sleepContext(ctx, delay); err != nil {
+		return 0, err
+	}
+	size, err := d.saveSegment(ctx, segment, retries-1)
+	return size, d.gaveUp(ctx, retries, err)
+}
+
+// Counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Runs check once r is exhausted, replacing EOF with its error
+type eofCheckReader struct {
+	r     io.Reader
+	check func() error
+}
+
+func (c *eofCheckReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if err == io.EOF {
+		if checkErr := c.check(); checkErr != nil {
+			return n, checkErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,105 @@
+package m3u8dl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	manifestFile      = "manifest.json"
+	manifestSaveEvery = 20 // Persist after this many completed segments
+)
+
+// Record of a download's progress, persisted in the temp dir for resume
+type manifest struct {
+	URL      string          `json:"url"`
+	Segments []manifestEntry `json:"segments"`
+
+	path    string
+	mu      sync.Mutex
+	pending int
+}
+
+type manifestEntry struct {
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+	Done bool   `json:"done"`
+}
+
+// Load the manifest from a previous run, starting fresh if it belongs to
+// another playlist or the segment list changed
+func (d *Downloader) loadManifest() *manifest {
+	m := &manifest{
+		URL:      d.opts.URL,
+		Segments: make([]manifestEntry, len(d.segments)),
+		path:     filepath.Join(d.outputDir, manifestFile),
+	}
+	for i, segment := range d.segments {
+		m.Segments[i].URL = segment.URL
+	}
+
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		return m
+	}
+
+	var prev manifest
+	if err := json.Unmarshal(data, &prev); err != nil || prev.URL != m.URL || len(prev.Segments) != len(m.Segments) {
+		return m
+	}
+
+	resumed := 0
+	for i, entry := range prev.Segments {
+		if entry.Done && entry.URL == m.Segments[i].URL {
+			m.Segments[i] = entry
+			resumed++
+		}
+	}
+	if resumed > 0 {
+		d.emit("resume", map[string]interface{}{"done": resumed, "total": len(m.Segments)},
+			"♻️  Resuming: %d/%d segments already downloaded\n", resumed, len(m.Segments))
+	}
+	return m
+}
+
+// Report whether a segment finished in a previous run and its file is intact
+func (m *manifest) completed(index int, path string) bool {
+	m.mu.Lock()
+	entry := m.Segments[index]
+	m.mu.Unlock()
+
+	if !entry.Done {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Size() == entry.Size
+}
+
+// Record a finished segment, periodically flushing the manifest to disk
+func (m *manifest) markDone(index int, size int64) {
+	m.mu.Lock()
+	m.Segments[index].Done = true
+	m.Segments[index].Size = size
+	m.pending++
+	flush := m.pending >= manifestSaveEvery
+	m.mu.Unlock()
+
+	if flush {
+		m.save()
+	}
+}
+
+// Write the manifest to the temp dir
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	m.pending = 0
+	return writeFileAtomic(m.path, data)
+}
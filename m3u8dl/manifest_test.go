@@ -0,0 +1,77 @@
+package m3u8dl
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResumeRefetchesTruncatedSegment(t *testing.T) {
+	var hits [3]int32
+	var failLast atomic.Bool
+	failLast.Store(true)
+	segment := func(i int, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits[i], 1)
+			if i == 2 && failLast.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(body))
+		}
+	}
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXTINF:1,\n2.ts\n#EXT-X-ENDLIST\n"),
+		"/0.ts":       segment(0, "Gzero|"),
+		"/1.ts":       segment(1, "Gone|"),
+		"/2.ts":       segment(2, "Gtwo|"),
+	})
+	dir := t.TempDir()
+	opts := Options{
+		URL:        server.URL + "/video.m3u8",
+		OutputFile: filepath.Join(dir, "out.ts"),
+		TempDir:    filepath.Join(dir, "temp"),
+		Retries:    -1,
+		Resume:     true,
+		Events:     discardWriter{},
+	}
+	ctx := context.Background()
+
+	first := NewDownloader(opts)
+	if err := first.ParseM3U8(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.DownloadSegments(ctx); err == nil {
+		t.Fatal("first run succeeded despite the failing segment")
+	}
+	// A write cut short, e.g. by a crash, leaves a file shorter than recorded
+	if err := os.Truncate(first.segmentPath(first.segments[0]), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	failLast.Store(false)
+	second := NewDownloader(opts)
+	if err := second.ParseM3U8(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.DownloadSegments(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.MergeSegments(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(opts.OutputFile)
+	if string(got) != "Gzero|Gone|Gtwo|" {
+		t.Errorf("output = %q", got)
+	}
+	// The probe reads the first segment once per run as well
+	if hits[1] != 1 {
+		t.Errorf("intact segment fetched %d times, want 1", hits[1])
+	}
+	if hits[0] < 2+2 {
+		t.Errorf("truncated segment fetched %d times, want it downloaded again", hits[0])
+	}
+}
@@ -0,0 +1,199 @@
+package m3u8dl
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Media is one #EXT-X-MEDIA rendition (alternate audio, subtitles, ...) of a master playlist
+type Media struct {
+	Type     string `json:"type"` // AUDIO, SUBTITLES, VIDEO or CLOSED-CAPTIONS
+	GroupID  string `json:"group_id"`
+	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	URI      string `json:"uri,omitempty"` // Empty when the rendition is muxed into the variant
+	Default  bool   `json:"default,omitempty"`
+}
+
+var attributeRegex = regexp.MustCompile(`([A-Z0-9-]+)=("[^"]*"|[^,]*)`)
+
+// Split an attribute list into its KEY=VALUE pairs, unquoting values
+func parseAttributes(line string) map[string]string {
+	attrs := make(map[string]string)
+	if i := strings.Index(line, ":"); i >= 0 {
+		line = line[i+1:]
+	}
+	for _, match := range attributeRegex.FindAllStringSubmatch(line, -1) {
+		attrs[match[1]] = strings.Trim(match[2], `"`)
+	}
+	return attrs
+}
+
+// Parse all #EXT-X-MEDIA renditions from a master playlist
+func (d *Downloader) parseMedia(content string) []Media {
+	baseURL := d.playlistBase()
+	var media []Media
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-MEDIA:") {
+			continue
+		}
+		attrs := parseAttributes(line)
+		m := Media{
+			Type:     attrs["TYPE"],
+			GroupID:  attrs["GROUP-ID"],
+			Language: attrs["LANGUAGE"],
+			Name:     attrs["NAME"],
+			Default:  attrs["DEFAULT"] == "YES",
+		}
+		if attrs["URI"] != "" {
+			m.URI = d.resolveURL(baseURL, attrs["URI"])
+		}
+		media = append(media, m)
+	}
+	return media
+}
+
+// Pick the rendition of a group matching lang ("en" matches "en-US"), else the
+// DEFAULT=YES one, else the first. The bool reports whether lang was matched.
+func selectMedia(media []Media, mediaType, group, lang string) (*Media, bool) {
+	var first, fallback *Media
+	for i := range media {
+		m := &media[i]
+		if m.Type != mediaType || m.GroupID != group {
+			continue
+		}
+		if lang != "" && (strings.EqualFold(m.Language, lang) ||
+			strings.HasPrefix(strings.ToLower(m.Language), strings.ToLower(lang)+"-")) {
+			return m, true
+		}
+		if first == nil {
+			first = m
+		}
+		if m.Default && fallback == nil {
+			fallback = m
+		}
+	}
+	if fallback == nil {
+		fallback = first
+	}
+	return fallback, lang == ""
+}
+
+// Remember the separate audio rendition the selected variant needs, if any
+func (d *Downloader) pickAudio(media []Media, variant Variant) {
+	if variant.Audio == "" {
+		if d.opts.Audio != "" {
+			d.emit("warning", map[string]interface{}{"message": "variant has no separate audio"},
+				"⚠️  Variant has no separate audio tracks, ignoring -audio %s\n", d.opts.Audio)
+		}
+		return
+	}
+
+	audio, matched := selectMedia(media, "AUDIO", variant.Audio, d.opts.Audio)
+	// Without a URI the audio is already muxed into the variant's segments
+	if audio == nil || audio.URI == "" {
+		return
+	}
+	if !matched {
+		d.emit("warning", map[string]interface{}{"message": "requested audio language not found", "language": d.opts.Audio},
+			"⚠️  No %q audio track, using %s\n", d.opts.Audio, mediaLabel(audio))
+	}
+	if d.opts.Live {
+		d.emit("warning", map[string]interface{}{"message": "separate audio is not recorded in live mode"},
+			"⚠️  Variant keeps audio in a separate track, which is not recorded in live mode\n")
+		return
+	}
+	// Muxing needs ffmpeg to read the finished video back
+	if d.toStdout() {
+		d.emit("warning", map[string]interface{}{"message": "separate audio can't be muxed into stdout"},
+			"⚠️  Variant keeps audio in a separate track, which can't be muxed into stdout; piping video only\n")
+		return
+	}
+	d.audio = audio
+	d.emit("audio_selected", map[string]interface{}{"language": audio.Language, "name": audio.Name, "url": audio.URI},
+		"🔊 Separate audio track: %s\n", mediaLabel(audio))
+}
+
+// Human-readable name of a rendition
+func mediaLabel(m *Media) string {
+	switch {
+	case m.Name != "" && m.Language != "":
+		return m.Name + " (" + m.Language + ")"
+	case m.Name != "":
+		return m.Name
+	case m.Language != "":
+		return m.Language
+	}
+	return m.GroupID
+}
+
+// DownloadAudio fetches the separate audio rendition picked for the variant,
+// if any, into a file next to the output. Remux muxes it into the video.
+func (d *Downloader) DownloadAudio(ctx context.Context) error {
+	if d.audio == nil {
+		return nil
+	}
+
+	opts := d.childOptions()
+	opts.URL = d.audio.URI
+	opts.TempDir = filepath.Join(d.outputDir, "audio")
+	opts.OutputFile = ""
+	opts.Remux = false
+	// Only the video output is pipelined, the audio track merges afterwards
+	opts.PipelineMerge = false
+	// The checksum list describes the video segments, whose indexes and file
+	// names the audio segments would collide with
+	opts.Checksums = nil
+	// Segment positions differ between renditions, so cover the video's
+	// playback span instead
+	opts.StartSegment, opts.EndSegment = 0, 0
+	opts.StartTime, opts.EndTime = d.rangeStart, d.rangeEnd
+	opts.Audio = ""
+	sub := NewDownloader(opts)
+	// Share the connection pool and bandwidth budget with the video
+	sub.client = d.client
+	sub.limiter = d.limiter
+	// Renditions usually share the master playlist's session keys
+	sub.keyCache = d.keyCache
+
+	d.emit("audio_start", map[string]interface{}{"url": d.audio.URI}, "\n🔊 Downloading audio track...\n")
+	if err := sub.parsePlaylist(ctx, make(map[string]bool)); err != nil {
+		return fmt.Errorf("audio track: %w", err)
+	}
+	ext := audioExt(sub)
+	sub.outputFile = strings.TrimSuffix(d.outputFile, filepath.Ext(d.outputFile)) + ".audio" + ext
+	if err := d.checkSideOutput(sub.outputFile); err != nil {
+		return fmt.Errorf("audio track: %w", err)
+	}
+	// Raw elementary streams have no TS packets to check after decryption
+	if ext != ".ts" && ext != ".mp4" {
+		sub.opts.SkipTSCheck = true
+	}
+	if err := sub.DownloadSegments(ctx); err != nil {
+		return fmt.Errorf("audio track: %w", err)
+	}
+	if err := sub.MergeSegments(); err != nil {
+		return fmt.Errorf("audio track: %w", err)
+	}
+	d.audioFile = sub.outputFile
+	return nil
+}
+
+// File extension for a merged audio rendition, keeping raw elementary streams as-is
+func audioExt(sub *Downloader) string {
+	if sub.initSegment == nil && len(sub.segments) > 0 {
+		if u, err := url.Parse(sub.segments[0].URL); err == nil {
+			switch ext := strings.ToLower(path.Ext(u.Path)); ext {
+			case ".aac", ".ac3", ".ec3", ".mp3":
+				return ext
+			}
+		}
+	}
+	return filepath.Ext(sub.outputFile)
+}
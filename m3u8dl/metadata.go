@@ -0,0 +1,77 @@
+package m3u8dl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Metadata describes a finished download, written next to the output by
+// WriteMetadata so archived videos document where they came from
+type Metadata struct {
+	Source          string     `json:"source"`            // URL, path or "data:" the download started from
+	Playlist        string     `json:"playlist"`          // Media playlist the segments came from
+	Variant         *Variant   `json:"variant,omitempty"` // Chosen variant when Source was a master playlist
+	Audio           *Media     `json:"audio,omitempty"`   // Separate audio rendition, if one was fetched
+	Output          string     `json:"output"`
+	Container       string     `json:"container"`
+	Duration        float64    `json:"duration"` // Seconds, summed from EXTINF
+	Segments        int        `json:"segments"`
+	MissingSegments []int      `json:"missing_segments,omitempty"` // Failed segments skipped with ContinueOnError
+	Encryption      string     `json:"encryption,omitempty"`       // e.g. "AES-128"
+	StartTime       *time.Time `json:"start_time,omitempty"`       // From EXT-X-PROGRAM-DATE-TIME
+	Bytes           int64      `json:"bytes"`                      // Size of the output file
+	DownloadedAt    time.Time  `json:"downloaded_at"`
+}
+
+// Metadata describes the download so far; call it after the last step for
+// the final output name
+func (d *Downloader) Metadata() Metadata {
+	summary := d.Summary()
+	m := Metadata{
+		Source:       d.opts.URL,
+		Playlist:     d.m3u8URL,
+		Variant:      d.variant,
+		Audio:        d.audio,
+		Output:       d.outputFile,
+		Container:    summary.Container,
+		Duration:     summary.Duration.Seconds(),
+		Segments:     summary.Segments,
+		Encryption:   summary.KeyMethod,
+		DownloadedAt: time.Now().UTC(),
+	}
+	// Inline playlists would repeat the whole playlist
+	if strings.HasPrefix(m.Source, "data:") {
+		m.Source = "data:"
+	}
+	if strings.HasPrefix(m.Playlist, "data:") {
+		m.Playlist = "data:"
+	}
+	if info, err := os.Stat(d.outputFile); err == nil {
+		m.Bytes = info.Size()
+	}
+	if !summary.StartTime.IsZero() {
+		m.StartTime = &summary.StartTime
+	}
+	for _, f := range d.sortedFailures() {
+		m.MissingSegments = append(m.MissingSegments, f.Index)
+	}
+	return m
+}
+
+// WriteMetadata saves Metadata as JSON next to the output, named after it
+// with a .json extension, and returns the path
+func (d *Downloader) WriteMetadata() (string, error) {
+	data, err := json.MarshalIndent(d.Metadata(), "", "  ")
+	if err != nil {
+		return "", err
+	}
+	path := strings.TrimSuffix(d.outputFile, filepath.Ext(d.outputFile)) + ".json"
+	if err := writeFileAtomic(path, append(data, '\n')); err != nil {
+		return "", err
+	}
+	d.emit("metadata", map[string]interface{}{"path": path}, "🗂️  Metadata written to %s\n", path)
+	return path, nil
+}
@@ -0,0 +1,96 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StdoutOutput as Options.OutputFile writes the merged stream to stdout, for
+// piping into another program
+const StdoutOutput = "-"
+
+// Whether the merged stream goes to stdout instead of a file
+func (d *Downloader) toStdout() bool {
+	return d.outputFile == StdoutOutput
+}
+
+// Leaves stdout open when the merge is done
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Create the file the merged stream is written to, or hand out stdout
+func (d *Downloader) createOutput() (io.WriteCloser, error) {
+	if d.toStdout() {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(d.outputFile)
+}
+
+// Make sure the download won't clobber an earlier one. An explicit
+// OutputFile that already exists is an error; the default name moves to the
+// first free "_<n>" suffix instead. Stdout can't be remuxed or kept.
+func (d *Downloader) checkOutput() error {
+	if d.toStdout() && (d.opts.Remux || d.opts.KeepSegments) {
+		return fmt.Errorf("output to stdout can't be remuxed or kept as segments")
+	}
+	if d.opts.Force || d.opts.DryRun || d.opts.ListVariants || d.opts.KeepSegments || d.toStdout() {
+		return nil
+	}
+	taken := d.takenOutput()
+	if taken == "" {
+		return nil
+	}
+	if d.opts.OutputFile != "" {
+		return fmt.Errorf("%w: %s, use -force to overwrite it", ErrOutputExists, taken)
+	}
+
+	ext := filepath.Ext(d.outputFile)
+	base := strings.TrimSuffix(d.outputFile, ext)
+	outputFile, mp4File := d.outputFile, d.mp4File
+	for n := 1; taken != ""; n++ {
+		d.outputFile = fmt.Sprintf("%s_%d%s", base, n, ext)
+		if mp4File != "" {
+			d.mp4File = fmt.Sprintf("%s_%d.mp4", base, n)
+		}
+		taken = d.takenOutput()
+	}
+	renamed := d.outputFile
+	if d.mp4File != "" {
+		outputFile, renamed = mp4File, d.mp4File
+	}
+	d.emit("output_renamed", map[string]interface{}{"output": renamed, "existing": outputFile},
+		"📄 %s already exists, saving to %s\n", outputFile, renamed)
+	return nil
+}
+
+// First of the files the download would write that already has data in it
+func (d *Downloader) takenOutput() string {
+	for _, path := range []string{d.outputFile, d.mp4File} {
+		if path == "" {
+			continue
+		}
+		if fileTaken(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Whether path is a file with data in it
+func fileTaken(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular() && info.Size() > 0
+}
+
+// Refuse to overwrite a file written next to the output, such as the audio
+// track, unless Force is set
+func (d *Downloader) checkSideOutput(path string) error {
+	if !d.opts.Force && fileTaken(path) {
+		return fmt.Errorf("%w: %s, use -force to overwrite it", ErrOutputExists, path)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// MergeCompleted saves what an interrupted DownloadSegments finished: the
+// segments up to the first missing one are merged into the output and the
+// rest dropped. It returns how many segments were saved. Stream mode already
+// wrote them, so only the count is reported.
+func (d *Downloader) MergeCompleted() (int, error) {
+	if d.opts.KeepSegments {
+		return 0, fmt.Errorf("kept segments aren't merged")
+	}
+	total := len(d.segments)
+	saved := d.completedPrefix()
+	if saved == 0 {
+		d.dropPartialOutput()
+		d.emit("partial_merged", map[string]interface{}{"segments": 0, "total": total},
+			"💾 No segments finished, nothing to save\n")
+		return 0, nil
+	}
+
+	d.segments = d.segments[:saved]
+	d.totalDuration = 0
+	for _, segment := range d.segments {
+		d.totalDuration += time.Duration(segment.Duration * float64(time.Second))
+	}
+	switch {
+	case d.opts.Stream:
+	case d.pipeline != nil:
+		// Cut off the preallocated space of the segments that never came
+		if err := d.pipeline.file.Truncate(d.pipeline.offsets[saved]); err != nil {
+			d.pipeline.file.Close()
+			return 0, err
+		}
+		if err := d.finishPipeline(); err != nil {
+			return 0, err
+		}
+	default:
+		// A later -resume run still needs the segments
+		d.opts.KeepTemp = d.opts.KeepTemp || d.opts.Resume
+		if err := d.MergeSegments(); err != nil {
+			return 0, err
+		}
+	}
+	d.emit("partial_merged", map[string]interface{}{"segments": saved, "total": total,
+		"duration": d.totalDuration.Seconds(), "output": d.outputFile},
+		"💾 Saved the first %d of %d segments (%s) to %s\n", saved, total, d.totalDuration.Round(time.Second), d.outputFile)
+	return saved, nil
+}
+
+// Number of leading segments that finished downloading
+func (d *Downloader) completedPrefix() int {
+	if d.opts.Stream {
+		return d.streamed
+	}
+	if d.pipeline != nil {
+		for i, done := range d.pipeline.done {
+			if !done {
+				return i
+			}
+		}
+		return len(d.pipeline.done)
+	}
+	// fMP4 media segments are useless without their init segment
+	if d.initSegment != nil {
+		if _, err := os.Stat(filepath.Join(d.outputDir, "init.mp4")); err != nil {
+			return 0
+		}
+	}
+	// Segment files only appear under their final name once complete
+	for i, segment := range d.segments {
+		if _, err := os.Stat(d.segmentPath(segment)); err != nil || d.segmentFailed(i) {
+			return i
+		}
+	}
+	return len(d.segments)
+}
+
+// Remove an output that was started but holds no complete segment
+func (d *Downloader) dropPartialOutput() {
+	if d.pipeline != nil {
+		d.pipeline.file.Close()
+		d.pipeline = nil
+	} else if !d.opts.Stream {
+		return
+	}
+	if !d.toStdout() {
+		os.Remove(d.outputFile)
+	}
+}
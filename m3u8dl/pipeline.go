@@ -0,0 +1,187 @@
+package m3u8dl
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Output file that workers write segments into at precomputed offsets, so
+// merging happens during the download instead of after it
+type pipelinedOutput struct {
+	file    *os.File
+	offsets []int64 // Start of each segment by index, plus the end of the file
+	done    []bool  // Segments written so far, by index
+
+	finished bool // Flushed and closed by finishPipeline
+}
+
+// Preallocate the output and switch workers to positioned writes when every
+// segment's size is known up front. Otherwise the regular merge is used.
+func (d *Downloader) startPipeline(ctx context.Context) error {
+	if d.opts.Stream || d.opts.Resume || d.opts.Live || d.opts.KeepSegments {
+		d.emit("warning", map[string]interface{}{"message": "pipelined merge needs a plain download, merging afterwards"},
+			"⚠️  Pipelined merge doesn't combine with -stream, -resume, -live or -keep-segments, merging afterwards\n")
+		return nil
+	}
+	// Positioned writes need a file to seek in
+	if d.toStdout() {
+		d.emit("warning", map[string]interface{}{"message": "pipelined merge can't write to stdout, merging afterwards"},
+			"⚠️  Pipelined merge can't write to stdout, merging afterwards\n")
+		return nil
+	}
+
+	sizes, ok := d.segmentSizes(ctx)
+	if !ok {
+		d.emit("warning", map[string]interface{}{"message": "segment sizes unknown, merging afterwards"},
+			"⚠️  Segment sizes aren't known up front, merging after the download instead\n")
+		return nil
+	}
+
+	out, err := d.createOutput()
+	if err != nil {
+		return err
+	}
+	// Never stdout, which was ruled out above
+	file := out.(*os.File)
+	// fMP4 streams need the init segment ahead of any media data
+	if err := d.writeInit(file); err != nil {
+		file.Close()
+		return err
+	}
+	start, err := file.Seek(0, 1)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	offsets := make([]int64, len(sizes)+1)
+	offsets[0] = start
+	for i, size := range sizes {
+		offsets[i+1] = offsets[i] + size
+	}
+	// Sparse on most filesystems, so this doesn't write the zeros
+	if err := file.Truncate(offsets[len(sizes)]); err != nil {
+		file.Close()
+		return err
+	}
+
+	d.pipeline = &pipelinedOutput{file: file, offsets: offsets, done: make([]bool, len(sizes))}
+	d.emit("pipeline_merge", map[string]interface{}{"output": d.outputFile, "bytes": offsets[len(sizes)]},
+		"🧵 Writing segments straight into %s (%.2f MB)\n", d.outputFile, float64(offsets[len(sizes)])/(1<<20))
+	return nil
+}
+
+// Size of every segment as stored, from byte ranges or HEAD requests.
+// Encrypted segments lose their padding on decryption, so they never qualify.
+func (d *Downloader) segmentSizes(ctx context.Context) ([]int64, bool) {
+	sizes := make([]int64, len(d.segments))
+	unknown := int32(0)
+	semaphore := make(chan struct{}, d.opts.Workers)
+	var wg sync.WaitGroup
+
+	for i, segment := range d.segments {
+		if len(segment.Key) > 0 {
+			return nil, false
+		}
+		if segment.ByteLength > 0 {
+			sizes[i] = segment.ByteLength
+			continue
+		}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, segment *Segment) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			if atomic.LoadInt32(&unknown) == 1 {
+				return
+			}
+			size, err := d.contentLength(ctx, segment)
+			if err != nil || size < 0 {
+				atomic.StoreInt32(&unknown, 1)
+				return
+			}
+			sizes[i] = size
+		}(i, segment)
+	}
+	wg.Wait()
+	return sizes, unknown == 0 && len(sizes) > 0
+}
+
+// Content-Length of a segment from a HEAD request; -1 when not advertised
+func (d *Downloader) contentLength(ctx context.Context, segment *Segment) (int64, error) {
+	req, err := d.newRequest(ctx, "HEAD", d.segmentURL(segment))
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	// A compressed length says nothing about the decoded segment
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Encoding") != "" {
+		return -1, nil
+	}
+	// Responses from the file transport only carry the length as a header
+	if resp.ContentLength <= 0 {
+		if size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64); err == nil && size > 0 {
+			return size, nil
+		}
+		return -1, nil
+	}
+	return resp.ContentLength, nil
+}
+
+// Write a downloaded segment into its slot of the output
+func (p *pipelinedOutput) write(segment *Segment, data []byte) error {
+	want := p.offsets[segment.Index+1] - p.offsets[segment.Index]
+	if int64(len(data)) != want {
+		return fmt.Errorf("got %d bytes but the server advertised %d", len(data), want)
+	}
+	if _, err := p.file.WriteAt(data, p.offsets[segment.Index]); err != nil {
+		return err
+	}
+	p.done[segment.Index] = true
+	return nil
+}
+
+// Flush the pipelined output and record where discontinuities start
+func (d *Downloader) finishPipeline() error {
+	for _, segment := range d.segments {
+		if segment.Discontinuity {
+			d.boundaries = append(d.boundaries, d.pipeline.offsets[segment.Index])
+		}
+	}
+	if err := d.pipeline.file.Sync(); err != nil {
+		d.pipeline.file.Close()
+		return err
+	}
+	if err := d.pipeline.file.Close(); err != nil {
+		return err
+	}
+	d.pipeline.finished = true
+	if len(d.failures) > 0 {
+		d.emit("warning", map[string]interface{}{"message": "failed segments are zero-filled"},
+			"⚠️  Failed segments are left as zero-filled gaps in the output\n")
+	}
+	d.emit("merged", map[string]interface{}{"output": d.outputFile}, "✅ Merged into: %s\n", d.outputFile)
+	return nil
+}
+
+// Remove a preallocated output that finishPipeline never completed, which
+// would otherwise sit at the output path full of zeros
+func (d *Downloader) discardPipeline() {
+	if d.pipeline == nil || d.pipeline.finished {
+		return
+	}
+	d.pipeline.file.Close()
+	os.Remove(d.outputFile)
+	d.pipeline = nil
+}
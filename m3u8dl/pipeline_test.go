@@ -0,0 +1,78 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPipelineMerge(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8":  serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"),
+		"/0.ts":        serveContent("Gfirst|"),
+		"/1.ts":        serveContent("Gsecond"),
+		"/broken.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\nmissing.ts\n#EXT-X-ENDLIST\n"),
+		"/missing.ts": func(w http.ResponseWriter, r *http.Request) {
+			// Sized up front, but the download itself fails
+			if r.Method == http.MethodHead {
+				w.Header().Set("Content-Length", "7")
+				return
+			}
+			http.Error(w, "gone", http.StatusServiceUnavailable)
+		},
+	})
+
+	got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8", PipelineMerge: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gfirst|Gsecond" {
+		t.Errorf("output = %q", got)
+	}
+
+	output := filepath.Join(t.TempDir(), "out.ts")
+	_, _, err = downloadString(t, Options{
+		URL:           server.URL + "/broken.m3u8",
+		OutputFile:    output,
+		PipelineMerge: true,
+		Retries:       -1,
+	})
+	if err == nil {
+		t.Fatal("download succeeded with a failing segment")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("preallocated output left behind after a failed download: %v", err)
+	}
+}
+
+func TestPipelineMergeWithSubtitles(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",LANGUAGE=\"en\",NAME=\"English\",URI=\"subs.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,SUBTITLES=\"subs\"\nvideo.m3u8\n"),
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"),
+		"/subs.m3u8":  serveString("#EXTM3U\n#EXTINF:2,\nsubs.vtt\n#EXT-X-ENDLIST\n"),
+		"/0.ts":       serveContent("Gfirst|"),
+		"/1.ts":       serveContent("Gsecond"),
+		"/subs.vtt":   serveContent("WEBVTT\n\n00:00.000 --> 00:01.000\nHello\n"),
+	})
+	output := filepath.Join(t.TempDir(), "out.ts")
+	got, _, err := downloadString(t, Options{
+		URL:           server.URL + "/master.m3u8",
+		OutputFile:    output,
+		PipelineMerge: true,
+		Subtitles:     "en",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gfirst|Gsecond" {
+		t.Errorf("output = %q", got)
+	}
+	vtt, err := os.ReadFile(strings.TrimSuffix(output, ".ts") + ".en.vtt")
+	if err != nil || !strings.Contains(string(vtt), "Hello") {
+		t.Errorf("subtitles = %q, %v", vtt, err)
+	}
+}
@@ -0,0 +1,735 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type Segment struct {
+	Index      int
+	Sequence   int64 // Media sequence number
+	URL        string
+	Duration   float64
+	Key        []byte
+	IV         []byte
+	KeyMethod  string // EXT-X-KEY METHOD of Key; "" when the segment is in the clear
+	ByteStart  int64
+	ByteLength int64  // 0 means the whole resource
+	Ext        string // Extension of the file on disk, from the URL path; ".ts" when unknown
+	// Timestamps reset before this segment (EXT-X-DISCONTINUITY), e.g. around inserted ads
+	Discontinuity bool
+	// Discontinuities since the stream began, up to and including this
+	// segment's own: EXT-X-DISCONTINUITY-SEQUENCE plus the tags before it
+	DiscontinuitySequence int64
+	// Wall-clock time of the segment's first sample from EXT-X-PROGRAM-DATE-TIME,
+	// or carried forward from an earlier one by EXTINF durations; zero if the
+	// playlist has none
+	ProgramDateTime time.Time
+
+	data   []byte // Pending bytes in stream mode
+	mirror bool   // Stand-in from a redundant variant; refreshed URLs don't apply
+
+	retryDeadline time.Time // End of the retry budget for the current download; zero means none
+}
+
+// Longest playlist line accepted; signed segment URIs can carry huge tokens
+const maxPlaylistLine = 1 << 20
+
+// Master playlists may point at further master playlists; following more
+// than this many is treated as a loop
+const maxMasterDepth = 5
+
+// Parse M3U8 file and extract segments. Fails with ErrOutputExists when the
+// output would overwrite an earlier download, see Options.Force.
+func (d *Downloader) ParseM3U8(ctx context.Context) error {
+	if err := d.parsePlaylist(ctx, make(map[string]bool)); err != nil {
+		return err
+	}
+	return d.checkOutput()
+}
+
+// Parse the playlist at d.m3u8URL, following master playlists to a variant.
+// visited holds the master playlists already followed.
+func (d *Downloader) parsePlaylist(ctx context.Context, visited map[string]bool) error {
+	d.emit("fetch_playlist", map[string]interface{}{"url": d.m3u8URL}, "📥 Fetching m3u8 file...\n")
+	contentStr, err := d.fetchPlaylist(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Check if this is a master playlist (variant streams)
+	if strings.Contains(contentStr, "#EXT-X-STREAM-INF") {
+		// Listing only needs the variants, not a media playlist
+		if d.opts.ListVariants {
+			d.variants = d.parseVariants(contentStr)
+			d.iframeVariants = d.parseIFrameVariants(contentStr)
+			return nil
+		}
+
+		if visited[d.m3u8URL] {
+			return fmt.Errorf("master playlist refers back to itself: %s", d.m3u8URL)
+		}
+		if len(visited) >= maxMasterDepth {
+			return fmt.Errorf("master playlists nested more than %d levels deep", maxMasterDepth)
+		}
+		visited[d.m3u8URL] = true
+
+		d.emit("master_playlist", nil, "🎬 Detected master playlist, fetching best quality variant...\n")
+		variant, err := d.extractBestVariant(contentStr)
+		if err != nil {
+			return err
+		}
+		if d.playlistBase() == "" {
+			if err := checkResolved(variant.URL); err != nil {
+				return err
+			}
+		}
+		d.emit("variant", map[string]interface{}{"url": variant.URL}, "📍 Using variant: %s\n", variant.URL)
+		d.variant = &variant
+		if !d.refreshing {
+			d.preloadSessionKeys(ctx, contentStr)
+		}
+		media := d.parseMedia(contentStr)
+		d.pickAudio(media, variant)
+		d.pickSubtitles(media, variant)
+		if d.opts.Failover {
+			d.mirrorURLs = redundantVariants(d.variants, variant)
+		}
+		// Recursively fetch the actual segment playlist, which some
+		// services wrap in another master playlist
+		d.m3u8URL = variant.URL
+		return d.parsePlaylist(ctx, visited)
+	}
+
+	if err := d.parseMediaPlaylist(ctx, contentStr); err != nil {
+		return err
+	}
+	// A refresh only maps media sequence numbers to URLs
+	if d.refreshing {
+		return nil
+	}
+	if d.filtered > 0 {
+		d.emitFiltered(d.filtered)
+		if len(d.segments) == 0 && d.finalPlaylist() {
+			return fmt.Errorf("the segment filter left out all %d segments", d.filtered)
+		}
+	}
+	// Merging nothing would leave an empty output that looks like a success.
+	// A live recording may still see segments appear on a later reload.
+	if len(d.segments) == 0 && !d.opts.Live {
+		if d.finalPlaylist() {
+			return fmt.Errorf("playlist contained no segments")
+		}
+		return fmt.Errorf("playlist contained no segments yet (empty live window?), use -live to wait for them")
+	}
+	if d.segmentBase() == "" {
+		for _, segment := range d.segments {
+			if err := checkResolved(segment.URL); err != nil {
+				return err
+			}
+		}
+		if d.initSegment != nil {
+			if err := checkResolved(d.initSegment.URL); err != nil {
+				return err
+			}
+		}
+	}
+	if d.opts.StartSegment != 0 || d.opts.EndSegment != 0 || d.opts.StartTime != 0 || d.opts.EndTime != 0 {
+		// Reloads would keep appending past the end of the range
+		if d.opts.Live {
+			return fmt.Errorf("a segment or time range can't be used with a live stream")
+		}
+		if d.opts.StartSegment != 0 || d.opts.EndSegment != 0 {
+			if err := d.sliceSegments(d.opts.StartSegment, d.opts.EndSegment); err != nil {
+				return err
+			}
+		}
+		if d.opts.StartTime != 0 || d.opts.EndTime != 0 {
+			if err := d.sliceTime(d.opts.StartTime, d.opts.EndTime); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.emit("parsed", map[string]interface{}{"segments": len(d.segments), "duration": d.totalDuration.Seconds()},
+		"✅ Found %d segments, %s\n", len(d.segments), d.totalDuration.Round(time.Second))
+	if d.initSegment != nil {
+		d.emit("init_segment", map[string]interface{}{"url": d.initSegment.URL},
+			"🧩 Detected fMP4 stream with init segment: %s\n", d.initSegment.URL)
+	}
+	var boundaries []int
+	for _, segment := range d.segments {
+		if segment.Discontinuity {
+			boundaries = append(boundaries, segment.Index)
+		}
+	}
+	if start := d.StartTime(); !start.IsZero() {
+		d.emit("program_date_time", map[string]interface{}{"start": start.Format(time.RFC3339Nano)},
+			"🕒 Stream starts at %s\n", start.Format("2006-01-02 15:04:05.000 MST"))
+	}
+	if len(boundaries) > 0 {
+		d.emit("discontinuity", map[string]interface{}{"segments": boundaries},
+			"✂️  %d discontinuities (timestamps reset before segments %v)\n", len(boundaries), boundaries)
+	}
+	if !d.opts.Live {
+		switch {
+		case d.playlistType == "EVENT" && !d.endList:
+			d.emit("warning", map[string]interface{}{"message": "EVENT playlist is still growing"},
+				"⚠️  EVENT playlist is still being appended to, this grabs only what exists so far; use -live to keep recording\n")
+		case !d.finalPlaylist():
+			d.emit("warning", map[string]interface{}{"message": "playlist has no EXT-X-ENDLIST"},
+				"⚠️  Playlist has no EXT-X-ENDLIST (live stream?), use -live to keep recording\n")
+		}
+	}
+
+	if len(d.mirrorURLs) > 0 {
+		d.loadMirrors(ctx)
+	}
+
+	// fMP4 or raw audio segments don't belong in a .ts file
+	d.probeContainer(ctx)
+	d.planRemux()
+	return nil
+}
+
+// Fetch the current playlist body, retrying transient failures
+func (d *Downloader) fetchPlaylist(ctx context.Context) (string, error) {
+	var playlist string
+	err := d.retryFetch(ctx, "playlist", d.m3u8URL, func() (bool, error) {
+		var (
+			retry bool
+			err   error
+		)
+		playlist, retry, err = d.loadPlaylist(ctx)
+		return retry, err
+	})
+	return playlist, err
+}
+
+// Fetch the playlist body once, reporting whether a failure is worth retrying
+func (d *Downloader) loadPlaylist(ctx context.Context) (string, bool, error) {
+	if isLocalPlaylist(d.m3u8URL) {
+		// finalURL is the master playlist that named this one, if fetched over HTTP
+		if err := checkLocalAccess(d.finalURL, d.m3u8URL); err != nil {
+			return "", false, err
+		}
+		content, err := readLocalPlaylist(d.m3u8URL)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read m3u8: %w", err)
+		}
+		playlist := normalizePlaylist(string(content))
+		return playlist, false, checkPlaylist(playlist, "")
+	}
+
+	req, err := d.newRequest(ctx, "GET", d.m3u8URL)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid m3u8 url: %w", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to fetch m3u8: %w", err)
+	}
+	defer resp.Body.Close()
+	// Relative URIs resolve against where the playlist was served from, which
+	// differs from m3u8URL after a redirect
+	d.finalURL = resp.Request.URL.String()
+	d.logger.Debug("playlist response", "url", d.m3u8URL, "status", resp.StatusCode)
+	if d.finalURL != d.m3u8URL {
+		d.logger.Info("playlist redirected", "from", d.m3u8URL, "to", d.finalURL)
+	}
+
+	// Decoded here like segment bodies, since a user-supplied Accept-Encoding
+	// header stops Go from doing it transparently
+	body, err := decodeContent(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return "", true, fmt.Errorf("failed to fetch m3u8: %w", err)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return "", true, fmt.Errorf("failed to fetch m3u8: %w", err)
+	}
+	playlist := normalizePlaylist(string(content))
+	status := ""
+	if resp.StatusCode != http.StatusOK {
+		status = resp.Status
+	}
+	return playlist, transientStatus(resp.StatusCode), checkPlaylist(playlist, status)
+}
+
+// Bytes of a rejected playlist quoted in the error
+const snippetSize = 120
+
+// Reject anything that doesn't start with #EXTM3U. A wrong URL usually
+// serves an HTML page, whose lines would otherwise be taken for segment URIs.
+// status is the HTTP status when it wasn't 200 OK.
+func checkPlaylist(content, status string) error {
+	content = strings.TrimSpace(content)
+	if strings.HasPrefix(content, "#EXTM3U") {
+		return nil
+	}
+	snippet := content
+	if len(snippet) > snippetSize {
+		snippet = snippet[:snippetSize] + "..."
+	}
+	if status != "" {
+		return fmt.Errorf("not a valid m3u8 playlist (server returned %s), got: %q", status, snippet)
+	}
+	return fmt.Errorf("not a valid m3u8 playlist (no #EXTM3U header), got: %q", snippet)
+}
+
+// Strip a UTF-8 byte order mark and turn CRLF or bare CR line endings into
+// LF, so every line and tag prefix matches as written
+func normalizePlaylist(content string) string {
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	return strings.ReplaceAll(content, "\r", "\n")
+}
+
+// Parse a media playlist, appending segments that haven't been seen yet.
+// Live reloads call this repeatedly with a sliding window of segments.
+func (d *Downloader) parseMediaPlaylist(ctx context.Context, content string) error {
+	baseURL := d.segmentBase()
+	reload := len(d.segments) > 0
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxPlaylistLine)
+	var (
+		currentKey    []byte
+		currentIV     []byte
+		currentMethod string
+		duration      float64
+		position      int64 // Segment position within this playlist
+		// Pending EXT-X-BYTERANGE for the next segment; offset -1 means implicit
+		rangeLength   int64
+		rangeOffset   int64
+		rangeEnds     = make(map[string]int64)
+		discontinuity bool
+		// Wall-clock time of the next segment, once a PROGRAM-DATE-TIME was seen
+		programTime time.Time
+		// EXT-X-DISCONTINUITY-SEQUENCE plus the discontinuities seen so far
+		discontinuitySeq int64
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:") {
+			seq, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			if err == nil {
+				d.mediaSeq = seq
+			}
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-TARGETDURATION:") {
+			target, err := strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+			if err == nil && target > 0 {
+				d.targetDuration = time.Duration(target * float64(time.Second))
+			}
+		}
+
+		if line == "#EXT-X-ENDLIST" {
+			d.endList = true
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:") {
+			d.playlistType = strings.ToUpper(strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:"))
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:") {
+			seq, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"), 10, 64)
+			if err == nil {
+				discontinuitySeq = seq
+			}
+		}
+
+		if line == "#EXT-X-DISCONTINUITY" {
+			discontinuity = true
+			discontinuitySeq++
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:") {
+			if t, err := parseProgramDateTime(strings.TrimPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:")); err == nil {
+				programTime = t
+			} else {
+				d.logger.Warn("invalid program date time", "line", line, "error", err)
+			}
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-KEY:") {
+			var err error
+			currentKey, currentIV, err = d.parseKey(ctx, line, baseURL)
+			if err != nil {
+				return err
+			}
+			currentMethod = ""
+			if len(currentKey) > 0 {
+				currentMethod = parseKeyMethod(line)
+			}
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-MAP:") {
+			d.parseMap(line, baseURL, currentKey, currentIV)
+		}
+
+		if strings.HasPrefix(line, "#EXT-X-BYTERANGE:") {
+			length, offset, ok := parseByteRange(strings.TrimPrefix(line, "#EXT-X-BYTERANGE:"))
+			if ok {
+				rangeLength, rangeOffset = length, offset
+			}
+		}
+
+		// Tags, comments and blank lines may sit between an EXTINF and its
+		// URI, so the duration is held until the next URI line consumes it
+		if strings.HasPrefix(line, "#EXTINF:") {
+			duration = parseExtinf(line)
+		}
+
+		if !strings.HasPrefix(line, "#") && line != "" {
+			segmentURL := d.resolveURL(baseURL, line)
+			sequence := d.mediaSeq + position
+			position++
+
+			byteStart, byteLength := int64(0), int64(0)
+			if rangeLength > 0 {
+				// Without an explicit offset the range continues from the previous one on this URI
+				if rangeOffset < 0 {
+					rangeOffset = rangeEnds[segmentURL]
+				}
+				byteStart, byteLength = rangeOffset, rangeLength
+				rangeEnds[segmentURL] = rangeOffset + rangeLength
+				rangeLength = 0
+			}
+
+			// Segments without their own tag follow on from the previous one
+			segmentTime := programTime
+			if !programTime.IsZero() {
+				programTime = programTime.Add(time.Duration(duration * float64(time.Second)))
+			}
+
+			// Skip segments already collected by an earlier load of a live playlist
+			seenKey := fmt.Sprintf("%s@%d", segmentURL, byteStart)
+			if reload && (sequence <= d.lastSeq || d.seen[seenKey]) {
+				duration = 0
+				discontinuity = false
+				continue
+			}
+			d.seen[seenKey] = true
+			d.lastSeq = sequence
+
+			iv := currentIV
+			// Without an explicit IV, the media sequence number is used (RFC 8216 5.2)
+			if len(currentKey) > 0 && len(iv) == 0 {
+				iv = sequenceIV(sequence)
+			}
+			segment := &Segment{
+				Sequence:   sequence,
+				URL:        segmentURL,
+				Ext:        segmentExt(segmentURL),
+				Duration:   duration,
+				Key:        currentKey,
+				IV:         iv,
+				KeyMethod:  currentMethod,
+				ByteStart:  byteStart,
+				ByteLength: byteLength,
+
+				Discontinuity:         discontinuity,
+				DiscontinuitySequence: discontinuitySeq,
+				ProgramDateTime:       segmentTime,
+			}
+			// Numbering is left until a segment is kept, so the merge sees a
+			// contiguous list
+			if d.opts.Filter != nil && !d.opts.Filter(segment) {
+				d.filtered++
+				duration = 0
+				discontinuity = false
+				d.dropped = true
+				continue
+			}
+			// Timestamps jump where segments were dropped
+			if d.dropped && len(d.segments) > 0 {
+				segment.Discontinuity = true
+			}
+			d.dropped = false
+			segment.Index = len(d.segments)
+			d.segments = append(d.segments, segment)
+			d.totalDuration += time.Duration(duration * float64(time.Second))
+			// A segment without its own EXTINF must not inherit this one's duration
+			duration = 0
+			discontinuity = false
+		}
+	}
+
+	atomic.StoreInt32(&d.total, int32(len(d.segments)))
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to parse m3u8: %w", err)
+	}
+	return nil
+}
+
+// Parse EXT-X-MAP initialization segment (fMP4)
+func (d *Downloader) parseMap(line, baseURL string, key, iv []byte) {
+	uriRegex := regexp.MustCompile(`URI="([^"]+)"`)
+	uriMatch := uriRegex.FindStringSubmatch(line)
+	if len(uriMatch) < 2 {
+		return
+	}
+
+	initSeg := &Segment{
+		Index: -1,
+		URL:   d.resolveURL(baseURL, uriMatch[1]),
+		Key:   key,
+		IV:    iv,
+	}
+
+	rangeRegex := regexp.MustCompile(`BYTERANGE="([^"]+)"`)
+	if rangeMatch := rangeRegex.FindStringSubmatch(line); len(rangeMatch) > 1 {
+		length, offset, ok := parseByteRange(rangeMatch[1])
+		if ok {
+			initSeg.ByteLength = length
+			if offset >= 0 {
+				initSeg.ByteStart = offset
+			}
+		}
+	}
+
+	// Only a single init segment is written at the head of the output. A map
+	// naming another URI or byte range is reported but not used, so media
+	// after the switch may not play.
+	if d.initSegment != nil {
+		if d.initSegment.URL != initSeg.URL || d.initSegment.ByteStart != initSeg.ByteStart ||
+			d.initSegment.ByteLength != initSeg.ByteLength {
+			d.emit("warning", map[string]interface{}{"message": "EXT-X-MAP changes mid-stream"},
+				"⚠️  Playlist switches EXT-X-MAP mid-stream, keeping the first init segment; later media may not play\n")
+		}
+		return
+	}
+	d.initSegment = initSeg
+}
+
+// Parse an EXT-X-PROGRAM-DATE-TIME value. The spec asks for ISO 8601 with a
+// zone, which encoders write both as RFC 3339 and with a "+0000" style offset.
+func parseProgramDateTime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, expected ISO 8601 like 2024-01-31T12:00:00.000Z", value)
+}
+
+// Duration of an "#EXTINF:<duration>[,<title>]" line; 0 if it's malformed
+func parseExtinf(line string) float64 {
+	value := strings.TrimPrefix(line, "#EXTINF:")
+	if comma := strings.Index(value, ","); comma >= 0 {
+		value = value[:comma]
+	}
+	duration, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || duration < 0 {
+		return 0
+	}
+	return duration
+}
+
+// Parse a "<length>[@<offset>]" byte range; offset is -1 when omitted
+func parseByteRange(value string) (int64, int64, bool) {
+	parts := strings.SplitN(strings.TrimSpace(value), "@", 2)
+	length, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, false
+	}
+	offset := int64(-1)
+	if len(parts) == 2 {
+		offset, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || offset < 0 {
+			return 0, 0, false
+		}
+	}
+	return length, offset, true
+}
+
+var keyMethodRegex = regexp.MustCompile(`METHOD=([A-Za-z0-9-]+)`)
+
+// METHOD attribute of an EXT-X-KEY line, or "" without one
+func parseKeyMethod(line string) string {
+	if match := keyMethodRegex.FindStringSubmatch(line); len(match) > 1 {
+		return match[1]
+	}
+	return ""
+}
+
+// Parse encryption key from m3u8
+func (d *Downloader) parseKey(ctx context.Context, line, baseURL string) ([]byte, []byte, error) {
+	if method := parseKeyMethod(line); method != "" {
+		// METHOD=NONE clears encryption for the following segments
+		if method == "NONE" {
+			return nil, nil, nil
+		}
+		d.keyMethod = method
+		// A dry run only reports encryption, and a refresh keeps the keys it
+		// already has
+		if d.opts.DryRun || d.refreshing {
+			return nil, nil, nil
+		}
+		// SAMPLE-AES only encrypts parts of each NAL unit, whole-segment
+		// decryption would silently produce broken output
+		if method != "AES-128" {
+			return nil, nil, fmt.Errorf("unsupported encryption method %s (only AES-128 is supported)", method)
+		}
+	}
+
+	keyRegex := regexp.MustCompile(`URI="([^"]+)"`)
+	keyMatch := keyRegex.FindStringSubmatch(line)
+
+	var key, iv []byte
+
+	if len(keyMatch) > 1 {
+		if baseURL == "" {
+			if err := checkResolved(keyMatch[1]); err != nil {
+				return nil, nil, err
+			}
+		}
+		// Going on without the key would save the ciphertext as if it were video
+		var err error
+		key, err = d.fetchKey(ctx, d.resolveURL(baseURL, keyMatch[1]))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	iv, standard, err := parseIV(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	if iv != nil && !standard && !d.ivWarned {
+		d.ivWarned = true
+		d.emit("warning", map[string]interface{}{"message": "off-spec IV format", "iv": hex.EncodeToString(iv)},
+			"⚠️  Playlist has an off-spec IV (quoted or without 0x), using it anyway\n")
+		d.logger.Warn("off-spec IV format", "line", line)
+	}
+
+	return key, iv, nil
+}
+
+// Parse the IV attribute of an EXT-X-KEY or EXT-X-SESSION-KEY tag. The spec
+// form is IV=0x<32 hex digits>, but some playlists quote the value or leave
+// off the prefix; those are accepted with standard set to false.
+func parseIV(line string) (iv []byte, standard bool, err error) {
+	ivRegex := regexp.MustCompile(`[:,]IV=("?)(0[xX])?([0-9a-fA-F]+)"?`)
+	ivMatch := ivRegex.FindStringSubmatch(line)
+	if ivMatch == nil {
+		return nil, false, nil
+	}
+	iv, err = hex.DecodeString(ivMatch[3])
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, false, fmt.Errorf("invalid IV %q: must be %d bytes of hex", ivMatch[3], aes.BlockSize)
+	}
+	return iv, ivMatch[1] == "" && ivMatch[2] != "", nil
+}
+
+// Fetch key bytes, reusing previously downloaded or session keys for the
+// same URI
+func (d *Downloader) fetchKey(ctx context.Context, keyURL string) ([]byte, error) {
+	if key, ok := d.keyCache[keyURL]; ok {
+		return key, nil
+	}
+
+	var key []byte
+	err := d.retryFetch(ctx, "key", keyURL, func() (bool, error) {
+		var (
+			retry bool
+			err   error
+		)
+		key, retry, err = d.requestKey(ctx, keyURL)
+		return retry, err
+	})
+	if err != nil {
+		d.logger.Warn("key fetch failed", "url", keyURL, "error", err)
+		return nil, fmt.Errorf("failed to fetch key %s: %w", keyURL, err)
+	}
+	d.logger.Debug("fetched key", "url", keyURL, "bytes", len(key))
+	d.keyCache[keyURL] = key
+	return key, nil
+}
+
+// Fetch a key once, reporting whether a failure is worth retrying
+func (d *Downloader) requestKey(ctx context.Context, keyURL string) ([]byte, bool, error) {
+	req, err := d.newRequest(ctx, "GET", keyURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid key url: %w", err)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, transientStatus(resp.StatusCode), fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+	key, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+	return key, false, nil
+}
+
+// Base URL for the URIs of the last fetched playlist
+func (d *Downloader) playlistBase() string {
+	if isLocalPlaylist(d.m3u8URL) {
+		return d.localBase(d.m3u8URL)
+	}
+	if d.finalURL != "" {
+		return d.getBaseURL(d.finalURL)
+	}
+	return d.getBaseURL(d.m3u8URL)
+}
+
+// Base URL for segment, init and key URIs. -base-url replaces the playlist's
+// own location for providers that serve media from a different CDN path.
+func (d *Downloader) segmentBase() string {
+	if d.opts.BaseURL != "" {
+		return d.opts.BaseURL
+	}
+	return d.playlistBase()
+}
+
+// Get base URL for resolving relative paths
+func (d *Downloader) getBaseURL(urlStr string) string {
+	u, _ := url.Parse(urlStr)
+	pathParts := strings.Split(u.Path, "/")
+	basePath := strings.Join(pathParts[:len(pathParts)-1], "/")
+	return u.Scheme + "://" + u.Host + basePath + "/"
+}
+
+// Resolve relative URLs
+func (d *Downloader) resolveURL(baseURL, path string) string {
+	// Absolute file:// URIs are kept too, for newRequest to refuse when
+	// they come from a remote playlist
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.HasPrefix(path, "file://") {
+		return path
+	}
+	// "//host/path" keeps only the scheme, e.g. a key server on another host
+	if strings.HasPrefix(path, "//") {
+		u, _ := url.Parse(baseURL)
+		return u.Scheme + ":" + path
+	}
+	// If path starts with /, it's absolute path from domain root, which is
+	// where DRM key endpoints often live
+	if strings.HasPrefix(path, "/") {
+		u, _ := url.Parse(baseURL)
+		return u.Scheme + "://" + u.Host + path
+	}
+	// Otherwise, relative to base URL
+	return baseURL + path
+}
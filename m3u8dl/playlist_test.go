@@ -0,0 +1,488 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyMethodNone(t *testing.T) {
+	plain := []string{"Gfirst|", "Gsecond|", "Gthird|", "Gfourth|"}
+	handlers := map[string]http.HandlerFunc{
+		"/key": serveString(string(testKey)),
+		"/video.m3u8": serveString("#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:0\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"/key\"\n#EXTINF:1,\n0.ts\n#EXTINF:1,\n1.ts\n" +
+			"#EXT-X-KEY:METHOD=NONE\n#EXTINF:1,\n2.ts\n#EXTINF:1,\n3.ts\n#EXT-X-ENDLIST\n"),
+	}
+	for i, data := range plain {
+		body := []byte(data)
+		if i < 2 {
+			body = encryptSegment(t, body, testKey, sequenceIV(int64(i)))
+		}
+		handlers["/"+strconv.Itoa(i)+".ts"] = serveString(string(body))
+	}
+	server := newTestServer(t, handlers)
+
+	got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8", SkipTSCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Join(plain, ""); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		value          string
+		length, offset int64
+		ok             bool
+	}{
+		{"1024@0", 1024, 0, true},
+		{"1024@2048", 1024, 2048, true},
+		{" 512 ", 512, -1, true},
+		{"0@10", 0, 0, false},
+		{"-5", 0, 0, false},
+		{"10@-1", 0, 0, false},
+		{"abc@1", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		length, offset, ok := parseByteRange(tt.value)
+		if length != tt.length || offset != tt.offset || ok != tt.ok {
+			t.Errorf("parseByteRange(%q) = %d, %d, %v, want %d, %d, %v",
+				tt.value, length, offset, ok, tt.length, tt.offset, tt.ok)
+		}
+	}
+}
+
+func TestByteRangeSegments(t *testing.T) {
+	const blob = "GaaaGbbbbbGcc"
+	playlist := "#EXTM3U\n#EXTINF:1,\n#EXT-X-BYTERANGE:4@0\nblob.ts\n" +
+		"#EXTINF:1,\n#EXT-X-BYTERANGE:6\nblob.ts\n#EXTINF:1,\n#EXT-X-BYTERANGE:3\nblob.ts\n#EXT-X-ENDLIST\n"
+	tests := []struct {
+		name string
+		blob http.HandlerFunc
+	}{
+		{"ranged", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") == "" {
+				http.Error(w, "missing Range header", http.StatusBadRequest)
+				return
+			}
+			http.ServeContent(w, r, "blob.ts", time.Time{}, strings.NewReader(blob))
+		}},
+		// The whole file comes back and the range is cut out locally
+		{"range ignored", serveString(blob)},
+	}
+	for _, tt := range tests {
+		for _, lowMemory := range []bool{false, true} {
+			server := newTestServer(t, map[string]http.HandlerFunc{
+				"/video.m3u8": serveString(playlist),
+				"/blob.ts":    tt.blob,
+			})
+			got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8", LowMemory: lowMemory})
+			if err != nil {
+				t.Errorf("%s (low memory %v): %v", tt.name, lowMemory, err)
+			} else if got != blob {
+				t.Errorf("%s (low memory %v): output = %q, want %q", tt.name, lowMemory, got, blob)
+			}
+		}
+	}
+}
+
+func TestRedirectedPlaylistBase(t *testing.T) {
+	iv := make([]byte, 16)
+	segment := string(encryptSegment(t, []byte("Gmoved"), testKey, iv))
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		// Only the redirect target's directory holds the key and segment
+		"/old/video.m3u8": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/new/stream/video.m3u8", http.StatusFound)
+		},
+		"/new/stream/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key\",IV=0x00000000000000000000000000000000\n" +
+			"#EXTINF:1,\nseg0.ts\n#EXT-X-ENDLIST\n"),
+		"/new/stream/key":     serveString(string(testKey)),
+		"/new/stream/seg0.ts": serveString(segment),
+	})
+	got, _, err := downloadString(t, Options{URL: server.URL + "/old/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gmoved" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestMapByteRange(t *testing.T) {
+	// Init section and media segments packed into one file
+	const file = "INIT|moof1|moof2"
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"media.mp4\",BYTERANGE=\"5@0\"\n" +
+		"#EXTINF:1,\n#EXT-X-BYTERANGE:6@5\nmedia.mp4\n#EXTINF:1,\n#EXT-X-BYTERANGE:5\nmedia.mp4\n#EXT-X-ENDLIST\n"
+	for _, ranged := range []bool{true, false} {
+		var wholeFile int32
+		server := newTestServer(t, map[string]http.HandlerFunc{
+			"/video.m3u8": serveString(playlist),
+			"/media.mp4": func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Range") == "" {
+					atomic.AddInt32(&wholeFile, 1)
+				}
+				if !ranged {
+					r.Header.Del("Range")
+				}
+				http.ServeContent(w, r, "media.mp4", time.Time{}, strings.NewReader(file))
+			},
+		})
+		got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8"})
+		if err != nil {
+			t.Fatalf("ranged %v: %v", ranged, err)
+		}
+		if got != file {
+			t.Errorf("ranged %v: output = %q, want %q", ranged, got, file)
+		}
+		if wholeFile != 0 {
+			t.Errorf("ranged %v: %d requests without a Range header", ranged, wholeFile)
+		}
+	}
+}
+
+func TestNormalizePlaylist(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"#EXTM3U\n#EXTINF:1,\n0.ts\n", "#EXTM3U\n#EXTINF:1,\n0.ts\n"},
+		{"\ufeff#EXTM3U\n", "#EXTM3U\n"},
+		{"#EXTM3U\r\n#EXTINF:1,\r\n0.ts\r\n", "#EXTM3U\n#EXTINF:1,\n0.ts\n"},
+		{"#EXTM3U\r#EXTINF:1,\r0.ts", "#EXTM3U\n#EXTINF:1,\n0.ts"},
+		{"\ufeff#EXTM3U\r\n0.ts\r1.ts\n", "#EXTM3U\n0.ts\n1.ts\n"},
+	}
+	for _, tt := range tests {
+		if got := normalizePlaylist(tt.in); got != tt.want {
+			t.Errorf("normalizePlaylist(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCRLFAndBOMPlaylists(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("\ufeff#EXTM3U\r\n#EXT-X-STREAM-INF:BANDWIDTH=1000\r\nvideo.m3u8\r\n"),
+		"/video.m3u8": serveString("\ufeff#EXTM3U\r\n#EXT-X-TARGETDURATION:1\r\n" +
+			"#EXTINF:1,\r\n0.ts\r\n#EXTINF:1,\r\n1.ts\r\n#EXT-X-ENDLIST\r\n"),
+		"/0.ts": serveString("Gfirst|"),
+		"/1.ts": serveString("Gsecond"),
+	})
+	for _, playlist := range []string{"/master.m3u8", "/video.m3u8"} {
+		got, _, err := downloadString(t, Options{URL: server.URL + playlist})
+		if err != nil {
+			t.Fatalf("%s: %v", playlist, err)
+		}
+		if got != "Gfirst|Gsecond" {
+			t.Errorf("%s: output = %q", playlist, got)
+		}
+	}
+}
+
+func TestLongPlaylistLines(t *testing.T) {
+	token := strings.Repeat("x", 300*1024)
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n#EXT-X-SESSION-DATA:DATA-ID=\"blob\",VALUE=\"" + token + "\"\n" +
+			"#EXTINF:1,\n0.ts?token=" + token + "\n#EXT-X-ENDLIST\n"),
+		"/huge.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts?token=" + strings.Repeat(token, 4) + "\n#EXT-X-ENDLIST\n"),
+		"/0.ts": func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("token") != token {
+				http.Error(w, "bad token", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("Gsigned"))
+		},
+	})
+	got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gsigned" {
+		t.Errorf("output = %q", got)
+	}
+
+	// Past maxPlaylistLine the playlist is rejected rather than cut short
+	if _, _, err := downloadString(t, Options{URL: server.URL + "/huge.m3u8"}); err == nil {
+		t.Error("playlist with a line over 1MB was accepted")
+	}
+}
+
+func TestGzipPlaylist(t *testing.T) {
+	serveGzip := func(body string) http.HandlerFunc {
+		compressed := gzipBytes(body)
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed)
+		}
+	}
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveGzip("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000\nvideo.m3u8\n"),
+		"/video.m3u8":  serveGzip("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+		"/0.ts":        serveString("Gsegment"),
+	})
+	// Go decompresses by itself unless the request already asks for gzip
+	for _, headers := range []http.Header{nil, {"Accept-Encoding": {"gzip"}}} {
+		got, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8", Headers: headers})
+		if err != nil {
+			t.Fatalf("headers %v: %v", headers, err)
+		}
+		if got != "Gsegment" {
+			t.Errorf("headers %v: output = %q", headers, got)
+		}
+	}
+}
+
+func TestParseIV(t *testing.T) {
+	const digits = "000102030405060708090a0b0c0d0e0f"
+	want := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+	tests := []struct {
+		line         string
+		wantIV       bool
+		wantStandard bool
+		wantErr      bool
+	}{
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV=0x` + digits, true, true, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV=0X` + strings.ToUpper(digits), true, true, false},
+		{`#EXT-X-KEY:METHOD=AES-128,IV=0x` + digits + `,URI="k"`, true, true, false},
+		{`#EXT-X-SESSION-KEY:METHOD=AES-128,URI="k",IV=0x` + digits, true, true, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV="0x` + digits + `"`, true, false, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV=` + digits, true, false, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k"`, false, false, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k?IV=0x` + digits + `"`, false, false, false},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV=0x0102`, false, false, true},
+		{`#EXT-X-KEY:METHOD=AES-128,URI="k",IV=0x` + digits + "00", false, false, true},
+	}
+	for _, tt := range tests {
+		iv, standard, err := parseIV(tt.line)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseIV(%q) accepted the IV", tt.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseIV(%q): %v", tt.line, err)
+			continue
+		}
+		if tt.wantIV && !bytes.Equal(iv, want) || !tt.wantIV && iv != nil {
+			t.Errorf("parseIV(%q) = %x", tt.line, iv)
+		}
+		if standard != tt.wantStandard {
+			t.Errorf("parseIV(%q) standard = %v, want %v", tt.line, standard, tt.wantStandard)
+		}
+	}
+}
+
+func TestInterleavedTags(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n#EXT-X-TARGETDURATION:6\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:00Z\n#EXTINF:4,\n0.ts\n" +
+			// Tags, comments and blank lines between EXTINF and its URI
+			"#EXTINF:6,\n#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:00:10Z\n# comment\n\n1.ts\n" +
+			"#EXT-X-PROGRAM-DATE-TIME:2024-01-01T00:01:00Z\n#EXTINF:5,\n2.ts\n" +
+			// No EXTINF of its own; the previous duration was used up
+			"3.ts\n#EXT-X-ENDLIST\n"),
+	})
+	d := parseURL(t, server.URL+"/video.m3u8")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := []struct {
+		duration float64
+		at       time.Duration
+	}{
+		{4, 0},
+		{6, 10 * time.Second},
+		{5, time.Minute},
+		{0, time.Minute + 5*time.Second},
+	}
+	segments := d.Segments()
+	if len(segments) != len(want) {
+		t.Fatalf("%d segments, want %d", len(segments), len(want))
+	}
+	for i, w := range want {
+		if segments[i].Duration != w.duration {
+			t.Errorf("segment %d duration = %v, want %v", i, segments[i].Duration, w.duration)
+		}
+		if at := start.Add(w.at); !segments[i].ProgramDateTime.Equal(at) {
+			t.Errorf("segment %d program date time = %v, want %v", i, segments[i].ProgramDateTime, at)
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	d := NewDownloader(Options{Events: discardWriter{}})
+	const base = "https://cdn.example.com/streams/hd/"
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"seg0.ts", base + "seg0.ts"},
+		{"../audio/seg0.aac", base + "../audio/seg0.aac"},
+		{"/keys/key.bin", "https://cdn.example.com/keys/key.bin"},
+		{"/keys/key.bin?token=a/b", "https://cdn.example.com/keys/key.bin?token=a/b"},
+		{"//keys.example.com/key.bin", "https://keys.example.com/key.bin"},
+		{"http://other.example.com/seg.ts", "http://other.example.com/seg.ts"},
+		{"file:///media/seg.ts", "file:///media/seg.ts"},
+	}
+	for _, tt := range tests {
+		if got := d.resolveURL(base, tt.path); got != tt.want {
+			t.Errorf("resolveURL(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+	if got := d.resolveURL("http://localhost:8080/a/", "/key"); got != "http://localhost:8080/key" {
+		t.Errorf("root-relative URI lost the port: %q", got)
+	}
+}
+
+func TestRootRelativeKeyURIs(t *testing.T) {
+	iv := make([]byte, 16)
+	var server *httptest.Server
+	server = newTestServer(t, map[string]http.HandlerFunc{
+		"/streams/hd/video.m3u8": func(w http.ResponseWriter, r *http.Request) {
+			host := strings.TrimPrefix(server.URL, "http:")
+			w.Write([]byte("#EXTM3U\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"/drm/key\",IV=0x00000000000000000000000000000000\n" +
+				"#EXTINF:1,\n0.ts\n" +
+				"#EXT-X-KEY:METHOD=AES-128,URI=\"" + host + "/drm/other\",IV=0x00000000000000000000000000000000\n" +
+				"#EXTINF:1,\n1.ts\n#EXT-X-ENDLIST\n"))
+		},
+		"/drm/key":         serveString(string(testKey)),
+		"/drm/other":       serveString("fedcba9876543210"),
+		"/streams/hd/0.ts": serveString(string(encryptSegment(t, []byte("Groot|"), testKey, iv))),
+		"/streams/hd/1.ts": serveString(string(encryptSegment(t, []byte("Gprotocol"), []byte("fedcba9876543210"), iv))),
+	})
+	got, _, err := downloadString(t, Options{URL: server.URL + "/streams/hd/video.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Groot|Gprotocol" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestTransientStatus(t *testing.T) {
+	for code, want := range map[int]bool{
+		http.StatusInternalServerError: true,
+		http.StatusBadGateway:          true,
+		http.StatusServiceUnavailable:  true,
+		http.StatusRequestTimeout:      true,
+		http.StatusTooManyRequests:     true,
+		http.StatusNotFound:            false,
+		http.StatusForbidden:           false,
+		http.StatusUnauthorized:        false,
+		http.StatusGone:                false,
+	} {
+		if got := transientStatus(code); got != want {
+			t.Errorf("transientStatus(%d) = %v, want %v", code, got, want)
+		}
+	}
+}
+
+func TestPlaylistAndKeyRetry(t *testing.T) {
+	iv := make([]byte, 16)
+	tests := []struct {
+		name     string
+		path     string // Resource answering with failures first
+		failures []int
+		retries  int
+		wantHits int32
+		wantErr  bool
+	}{
+		{"playlist recovers", "/video.m3u8", []int{503, 429}, 2, 3, false},
+		{"playlist gives up", "/video.m3u8", []int{502, 502, 502}, 2, 3, true},
+		{"playlist not found", "/video.m3u8", []int{404}, 2, 1, true},
+		{"key recovers", "/key", []int{500, 408}, 2, 3, false},
+		{"key gives up", "/key", []int{503, 503}, 1, 2, true},
+		{"key forbidden", "/key", []int{403}, 2, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handlers := map[string]http.HandlerFunc{
+				"/video.m3u8": serveString("#EXTM3U\n" +
+					"#EXT-X-KEY:METHOD=AES-128,URI=\"key\",IV=0x00000000000000000000000000000000\n" +
+					"#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+				"/key":  serveString(string(testKey)),
+				"/0.ts": serveString(string(encryptSegment(t, []byte("Gdata"), testKey, iv))),
+			}
+			var hits int32
+			serve := handlers[tt.path]
+			handlers[tt.path] = func(w http.ResponseWriter, r *http.Request) {
+				if n := atomic.AddInt32(&hits, 1); int(n) <= len(tt.failures) {
+					http.Error(w, "failing", tt.failures[n-1])
+					return
+				}
+				serve(w, r)
+			}
+			server := newTestServer(t, handlers)
+			got, stats, err := downloadString(t, Options{
+				URL:     server.URL + "/video.m3u8",
+				Retries: tt.retries,
+				Backoff: time.Millisecond,
+			})
+			if tt.wantErr {
+				if err == nil {
+					t.Error("download succeeded")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				if got != "Gdata" {
+					t.Errorf("output = %q", got)
+				}
+				if stats.Retries != len(tt.failures) {
+					t.Errorf("Stats.Retries = %d, want %d", stats.Retries, len(tt.failures))
+				}
+			}
+			if hits != tt.wantHits {
+				t.Errorf("%s requested %d times, want %d", tt.path, hits, tt.wantHits)
+			}
+		})
+	}
+}
+
+func TestSessionKeyFailureIsNotFatal(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-SESSION-KEY:METHOD=AES-128,URI=\"missing-key\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000\nvideo.m3u8\n"),
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+		"/0.ts":       serveString("Gclear"),
+	})
+	got, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8", Retries: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gclear" {
+		t.Errorf("output = %q", got)
+	}
+}
+
+func TestChangedMapKeepsFirstInit(t *testing.T) {
+	const file = "INIT1|INIT2|moof1|moof2"
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MAP:URI=\"media.mp4\",BYTERANGE=\"6@0\"\n#EXTINF:1,\n#EXT-X-BYTERANGE:6@12\nmedia.mp4\n" +
+			"#EXT-X-MAP:URI=\"media.mp4\",BYTERANGE=\"6@6\"\n#EXTINF:1,\n#EXT-X-BYTERANGE:5@18\nmedia.mp4\n" +
+			"#EXT-X-ENDLIST\n"),
+		"/media.mp4": serveContent(file),
+	})
+	var events bytes.Buffer
+	got, _, err := downloadString(t, Options{URL: server.URL + "/video.m3u8", Events: NewTextWriter(&events)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "INIT1|moof1|moof2" {
+		t.Errorf("output = %q", got)
+	}
+	if !strings.Contains(events.String(), "switches EXT-X-MAP") {
+		t.Errorf("no warning about the changed map:\n%s", events.String())
+	}
+}
@@ -0,0 +1,66 @@
+package m3u8dl
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Minimum spacing between speed samples so the rate isn't dominated by noise
+const speedSampleInterval = 500 * time.Millisecond
+
+// Tracks the recent download rate for the progress line
+type speedMeter struct {
+	mu        sync.Mutex
+	start     time.Time
+	lastTime  time.Time
+	lastBytes int64
+	rate      float64 // Bytes per second over the latest sample
+	peak      float64 // Highest rate sampled
+}
+
+// Update the rate from the running byte total and return it
+func (m *speedMeter) sample(bytes int64) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(m.lastTime); elapsed >= speedSampleInterval || m.rate == 0 {
+		if elapsed > 0 {
+			m.rate = float64(bytes-m.lastBytes) / elapsed.Seconds()
+		}
+		// The first sample may cover only a moment, don't let it set the peak
+		if elapsed >= speedSampleInterval && m.rate > m.peak {
+			m.peak = m.rate
+		}
+		m.lastTime = now
+		m.lastBytes = bytes
+	}
+	return m.rate
+}
+
+// Print the progress line after a segment completes
+func (d *Downloader) reportProgress() {
+	current := atomic.AddInt32(&d.progress, 1)
+	total := atomic.LoadInt32(&d.total)
+	// Sampled even when OnProgress replaces the line, for the peak in Stats
+	rate := d.speed.sample(atomic.LoadInt64(&d.totalSize))
+	if d.opts.OnProgress != nil {
+		d.opts.OnProgress(int(current), int(total), atomic.LoadInt64(&d.totalSize))
+		return
+	}
+	percent := (float64(current) / float64(total)) * 100
+
+	// ETA from the average time per completed segment so far
+	eta := "--"
+	if current > 0 && current < total {
+		perSegment := time.Since(d.speed.start) / time.Duration(current)
+		eta = (perSegment * time.Duration(total-current)).Round(time.Second).String()
+	} else if current >= total {
+		eta = "0s"
+	}
+
+	bytes := atomic.LoadInt64(&d.totalSize)
+	d.emit("progress", map[string]interface{}{"done": current, "total": total, "bytes": bytes, "speed": int64(rate)},
+		"\r⬇️  Progress: %d/%d (%.1f%%) %.2f MB/s ETA %s   ", current, total, percent, rate/(1<<20), eta)
+}
@@ -0,0 +1,127 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ParseProxy parses a proxy URL, taking a bare "host:port" as an HTTP proxy
+// the way HTTP_PROXY does
+func ParseProxy(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", value, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q", value, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", value)
+	}
+	return u, nil
+}
+
+// Proxy function for the transport. Without Options.Proxy or NoProxy it is
+// http.ProxyFromEnvironment; each option replaces its environment variables.
+func newProxyFunc(opts Options) func(*http.Request) (*url.URL, error) {
+	if opts.Proxy == "" && opts.NoProxy == nil {
+		return http.ProxyFromEnvironment
+	}
+	noProxy := opts.NoProxy
+	if noProxy == nil {
+		noProxy = ParseNoProxy(getenv("NO_PROXY", "no_proxy"))
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		raw := opts.Proxy
+		if raw == "" && req.URL.Scheme == "https" {
+			raw = getenv("HTTPS_PROXY", "https_proxy")
+		} else if raw == "" && req.URL.Scheme == "http" {
+			raw = getenv("HTTP_PROXY", "http_proxy")
+		}
+		if raw == "" || !useProxy(req.URL, noProxy) {
+			return nil, nil
+		}
+		return ParseProxy(raw)
+	}
+}
+
+// First of the environment variables that is set
+func getenv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ParseNoProxy splits a NO_PROXY style "host1, .domain, 10.0.0.0/8" list; the
+// result is never nil, so an empty list still replaces NO_PROXY
+func ParseNoProxy(value string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// Whether a request goes through the proxy, with NO_PROXY's rules: "*"
+// bypasses everything, "example.com" covers it and its subdomains,
+// ".example.com" only the subdomains, and IPs or CIDR ranges match
+// addresses. An entry with a port only matches that port. Loopback hosts
+// are never proxied.
+func useProxy(u *url.URL, noProxy []string) bool {
+	host, port := strings.ToLower(u.Hostname()), u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return false
+	}
+	for _, entry := range noProxy {
+		entry = strings.ToLower(entry)
+		if entry == "*" {
+			return false
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return false
+			}
+			continue
+		}
+		entryHost, entryPort := entry, ""
+		if h, p, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = h, p
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return false
+			}
+			continue
+		}
+		entryHost = strings.TrimPrefix(entryHost, "*")
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return false
+			}
+		} else if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,99 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+func TestUseProxy(t *testing.T) {
+	noProxy := []string{"internal.example.com", ".corp.example", "10.0.0.0/8", "192.168.1.5", "media.example:8080"}
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://cdn.example.com/v.m3u8", true},
+		{"https://internal.example.com/v.m3u8", false},
+		{"https://keys.internal.example.com/key", false},
+		{"https://notinternal.example.com/v.m3u8", true},
+		{"https://a.corp.example/v.m3u8", false},
+		{"https://corp.example/v.m3u8", true},
+		{"http://10.1.2.3/seg.ts", false},
+		{"http://11.1.2.3/seg.ts", true},
+		{"http://192.168.1.5/seg.ts", false},
+		{"http://media.example:8080/seg.ts", false},
+		{"http://media.example/seg.ts", true},
+		{"http://localhost:8080/v.m3u8", false},
+		{"http://127.0.0.1/v.m3u8", false},
+		{"http://[::1]/v.m3u8", false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := useProxy(u, noProxy); got != tt.want {
+			t.Errorf("useProxy(%s) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+	u, _ := url.Parse("https://anything.example/v.m3u8")
+	if useProxy(u, []string{"*"}) {
+		t.Error(`"*" did not bypass the proxy`)
+	}
+}
+
+func TestNoProxyBypass(t *testing.T) {
+	proxy := newProxyFunc(Options{Proxy: "proxy.example:3128", NoProxy: []string{"direct.example"}})
+	for _, target := range []string{"https://direct.example/v.m3u8", "http://keys.direct.example/key"} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		if got, err := proxy(req); got != nil || err != nil {
+			t.Errorf("%s: proxied through %v (%v), want a direct connection", target, got, err)
+		}
+	}
+	req, _ := http.NewRequest(http.MethodGet, "https://cdn.example/seg.ts", nil)
+	if got, err := proxy(req); err != nil || got == nil || got.String() != "http://proxy.example:3128" {
+		t.Errorf("cdn.example: proxy = %v, %v", got, err)
+	}
+}
+
+func TestProxyCarriesAllRequests(t *testing.T) {
+	iv := make([]byte, 16)
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	record := func(handler http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			seen = append(seen, r.Host+r.URL.Path)
+			mu.Unlock()
+			handler(w, r)
+		}
+	}
+	// Stands in for a forward proxy; requests name a host only it can reach
+	proxy := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": record(serveString("#EXTM3U\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key\",IV=0x00000000000000000000000000000000\n" +
+			"#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n")),
+		"/key":  record(serveString(string(testKey))),
+		"/0.ts": record(serveString(string(encryptSegment(t, []byte("Gproxied"), testKey, iv)))),
+	})
+	got, _, err := downloadString(t, Options{
+		URL:     "http://media.invalid/video.m3u8",
+		Proxy:   proxy.URL,
+		NoProxy: []string{"direct.invalid"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gproxied" {
+		t.Errorf("output = %q", got)
+	}
+	for _, want := range []string{"media.invalid/video.m3u8", "media.invalid/key", "media.invalid/0.ts"} {
+		found := false
+		for _, s := range seen {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("no proxied request for %s, saw %v", want, seen)
+		}
+	}
+}
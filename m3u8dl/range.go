@@ -0,0 +1,102 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Keep only segments [start, end) of the playlist, renumbering them so the
+// merge sees a contiguous list. end 0 means through the last segment, and an
+// end past the last segment is clamped.
+func (d *Downloader) sliceSegments(start, end int) error {
+	total := len(d.segments)
+	if start < 0 || end < 0 {
+		return fmt.Errorf("segment range can't be negative: %d-%d", start, end-1)
+	}
+	if end == 0 || end > total {
+		end = total
+	}
+	if start >= total {
+		return fmt.Errorf("start segment %d is past the last segment %d", start, total-1)
+	}
+	if end <= start {
+		return fmt.Errorf("segment range %d-%d is inverted", start, end-1)
+	}
+
+	for _, segment := range d.segments[:start] {
+		d.rangeStart += time.Duration(segment.Duration * float64(time.Second))
+	}
+	d.segments = d.segments[start:end]
+	d.totalDuration = 0
+	for i, segment := range d.segments {
+		segment.Index = i
+		d.totalDuration += time.Duration(segment.Duration * float64(time.Second))
+	}
+	atomic.StoreInt32(&d.total, int32(len(d.segments)))
+	if end < total {
+		d.rangeEnd = d.rangeStart + d.totalDuration
+	}
+
+	d.emit("range", map[string]interface{}{"start": start, "end": end - 1, "total": total},
+		"✂️  Downloading segments %d-%d of %d\n", start, end-1, total)
+	return nil
+}
+
+// Keep the segments overlapping [start, end) of playback time, by summed
+// EXTINF durations. Boundary segments that are only partly inside the range
+// are kept whole; end 0 means through the last segment.
+func (d *Downloader) sliceTime(start, end time.Duration) error {
+	if start < 0 || end < 0 {
+		return fmt.Errorf("time range can't be negative")
+	}
+	if end != 0 && end <= start {
+		return fmt.Errorf("end time %s is not after start time %s", end, start)
+	}
+
+	first, last := -1, len(d.segments)
+	var at time.Duration
+	for i, segment := range d.segments {
+		next := at + time.Duration(segment.Duration*float64(time.Second))
+		if first < 0 && next > start {
+			first = i
+		}
+		if end != 0 && at >= end {
+			last = i
+			break
+		}
+		at = next
+	}
+	if first < 0 {
+		return fmt.Errorf("start time %s is past the end of the video (%s)", start, at.Round(time.Second))
+	}
+	return d.sliceSegments(first, last)
+}
+
+// ParseTimestamp parses a playback position given as "hh:mm:ss", "mm:ss"
+// (seconds may have a fraction), plain seconds or a Go duration like "5m30s"
+func ParseTimestamp(value string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return d, nil
+	}
+
+	parts := strings.Split(value, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q, expected hh:mm:ss", value)
+	}
+	var seconds float64
+	for i, part := range parts {
+		n, err := strconv.ParseFloat(part, 64)
+		// Only the seconds may have a fraction, and only hours may exceed 59
+		fractional := strings.Contains(part, ".") && i < len(parts)-1
+		digits := strings.Trim(part, "0123456789.") == ""
+		if err != nil || !digits || fractional || (i > 0 && n >= 60) {
+			return 0, fmt.Errorf("invalid time %q, expected hh:mm:ss", value)
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
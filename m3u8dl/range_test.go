@@ -0,0 +1,56 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRangeAppliesTimeSpanToAudio(t *testing.T) {
+	// Video in 4s segments, audio in 2s segments
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aud\",NAME=\"Main\",DEFAULT=YES,URI=\"audio.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,AUDIO=\"aud\"\nvideo.m3u8\n"),
+		"/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXTINF:4,\nv0.ts\n#EXTINF:4,\nv1.ts\n#EXTINF:4,\nv2.ts\n#EXT-X-ENDLIST\n"),
+		"/audio.m3u8": serveString("#EXTM3U\n" +
+			"#EXTINF:2,\na0.ts\n#EXTINF:2,\na1.ts\n#EXTINF:2,\na2.ts\n" +
+			"#EXTINF:2,\na3.ts\n#EXTINF:2,\na4.ts\n#EXTINF:2,\na5.ts\n#EXT-X-ENDLIST\n"),
+		"/": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("G" + r.URL.Path[1:3]))
+		},
+	})
+
+	tests := []struct {
+		name      string
+		opts      Options
+		wantVideo string
+		wantAudio string
+	}{
+		{"segment range", Options{StartSegment: 1, EndSegment: 2}, "Gv1", "Ga2Ga3"},
+		{"open-ended range", Options{StartSegment: 2}, "Gv2", "Ga4Ga5"},
+		{"time range", Options{StartTime: 5 * time.Second, EndTime: 7 * time.Second}, "Gv1", "Ga2Ga3"},
+		{"both", Options{StartSegment: 1, StartTime: 5 * time.Second}, "Gv2", "Ga4Ga5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.URL = server.URL + "/master.m3u8"
+			opts.OutputFile = filepath.Join(t.TempDir(), "out.ts")
+			got, _, err := downloadString(t, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.wantVideo {
+				t.Errorf("video = %q, want %q", got, tt.wantVideo)
+			}
+			audio, _ := os.ReadFile(filepath.Join(filepath.Dir(opts.OutputFile), "out.audio.ts"))
+			if string(audio) != tt.wantAudio {
+				t.Errorf("audio = %q, want %q", audio, tt.wantAudio)
+			}
+		})
+	}
+}
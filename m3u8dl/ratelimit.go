@@ -0,0 +1,103 @@
+package m3u8dl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token bucket shared by all workers so the aggregate rate is capped
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	rate := float64(bytesPerSec)
+	return &rateLimiter{
+		rate:   rate,
+		burst:  rate / 4, // Allow a quarter second of burst
+		tokens: rate / 4,
+		last:   time.Now(),
+	}
+}
+
+// Take n bytes from the bucket, sleeping while it is in debt
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		return sleepContext(ctx, delay)
+	}
+	return nil
+}
+
+// Reader throttled by a shared rateLimiter
+type limitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	// Keep reads small so the limiter can interleave workers smoothly
+	if chunk := int(lr.limiter.burst); chunk > 0 && len(p) > chunk {
+		p = p[:chunk]
+	}
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if waitErr := lr.limiter.wait(lr.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+var rateRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMG]?B)?(?:/S)?$`)
+
+// ParseRate parses a transfer rate like "5MB/s", "500KB" or "1.5GB/s" into
+// bytes per second; units are powers of 1024
+func ParseRate(value string) (int64, error) {
+	matches := rateRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid rate %q, expected e.g. 500KB/s, 5MB/s or 1GB/s", value)
+	}
+
+	amount, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", value, err)
+	}
+	switch matches[2] {
+	case "KB":
+		amount *= 1 << 10
+	case "MB":
+		amount *= 1 << 20
+	case "GB":
+		amount *= 1 << 30
+	}
+
+	if amount < 1 {
+		return 0, fmt.Errorf("rate %q must be at least 1 byte per second", value)
+	}
+	return int64(amount), nil
+}
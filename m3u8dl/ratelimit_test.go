@@ -0,0 +1,29 @@
+package m3u8dl
+
+import "testing"
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"5MB/s", 5 << 20, true},
+		{"500KB", 500 << 10, true},
+		{"1.5GB/s", 3 << 29, true},
+		{"2mb/s", 2 << 20, true},
+		{" 100 ", 100, true},
+		{"100B/s", 100, true},
+		{"0.5", 0, false},
+		{"0KB/s", 0, false},
+		{"fast", 0, false},
+		{"5 MiB/s", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseRate(%q) = %d, %v, want %d (ok %v)", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package m3u8dl
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Note a 403 for a segment and, once Options.RefreshOn403 of them piled up,
+// re-fetch the playlist for freshly signed segment URLs that retries pick up
+func (d *Downloader) noteForbidden(ctx context.Context) {
+	if d.opts.RefreshOn403 <= 0 {
+		return
+	}
+	if atomic.AddInt32(&d.forbiddenCount, 1) < int32(d.opts.RefreshOn403) {
+		return
+	}
+
+	d.refreshMu.Lock()
+	defer d.refreshMu.Unlock()
+	// Another worker may have refreshed while this one waited
+	if atomic.LoadInt32(&d.forbiddenCount) < int32(d.opts.RefreshOn403) {
+		return
+	}
+	atomic.StoreInt32(&d.forbiddenCount, 0)
+
+	d.emit("refresh_urls", map[string]interface{}{"url": d.opts.URL},
+		"\n🔑 Segments return 403, re-fetching the playlist for fresh URLs...\n")
+	urls, err := d.fetchFreshURLs(ctx)
+	if err != nil {
+		d.emit("warning", map[string]interface{}{"message": err.Error()}, "⚠️  Failed to refresh segment URLs: %v\n", err)
+		return
+	}
+	d.logger.Info("refreshed segment urls", "segments", len(urls))
+	d.urlMu.Lock()
+	d.freshURLs = urls
+	d.urlMu.Unlock()
+}
+
+// Parse the original playlist URL again, starting over at the master
+// playlist since variant URLs carry the same expiring tokens, and map each
+// media sequence number to its new URL
+func (d *Downloader) fetchFreshURLs(ctx context.Context) (map[int64]string, error) {
+	opts := d.childOptions()
+	opts.Events = discardWriter{}
+	// Only the segment URLs are wanted; a 403 while parsing must not start
+	// another refresh
+	opts.RefreshOn403 = 0
+	opts.Failover = false
+	fresh := NewDownloader(opts)
+	fresh.client = d.client
+	fresh.refreshing = true
+	if err := fresh.parsePlaylist(ctx, make(map[string]bool)); err != nil {
+		return nil, err
+	}
+
+	urls := make(map[int64]string, len(fresh.segments))
+	for _, segment := range fresh.segments {
+		urls[segment.Sequence] = segment.URL
+	}
+	return urls, nil
+}
+
+// URL to fetch a segment from, after any refresh
+func (d *Downloader) segmentURL(segment *Segment) string {
+	if segment.mirror {
+		return segment.URL
+	}
+	d.urlMu.RLock()
+	defer d.urlMu.RUnlock()
+	if url, ok := d.freshURLs[segment.Sequence]; ok {
+		return url
+	}
+	return segment.URL
+}
@@ -0,0 +1,68 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serve a playlist whose segment URLs carry a token that changes with every
+// fetch, as signed URLs do. Segments answer 403 to tokens that expired.
+func newSignedServer(t *testing.T, valid func(token int32) bool) (url string, fetches, forbidden *int32) {
+	fetches, forbidden = new(int32), new(int32)
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/video.m3u8": func(w http.ResponseWriter, r *http.Request) {
+			token := atomic.AddInt32(fetches, 1)
+			fmt.Fprintf(w, "#EXTM3U\n#EXTINF:1,\n0.ts?t=%d\n#EXTINF:1,\n1.ts?t=%d\n#EXT-X-ENDLIST\n", token, token)
+		},
+		"/": func(w http.ResponseWriter, r *http.Request) {
+			var token int32
+			fmt.Sscan(r.URL.Query().Get("t"), &token)
+			if !valid(token) {
+				atomic.AddInt32(forbidden, 1)
+				http.Error(w, "expired", http.StatusForbidden)
+				return
+			}
+			w.Write([]byte("G" + r.URL.Path))
+		},
+	})
+	return server.URL + "/video.m3u8", fetches, forbidden
+}
+
+func TestRefreshOn403(t *testing.T) {
+	url, fetches, _ := newSignedServer(t, func(token int32) bool { return token > 1 })
+	got, _, err := downloadString(t, Options{
+		URL:          url,
+		RefreshOn403: 1,
+		Backoff:      time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "G/0.tsG/1.ts" {
+		t.Errorf("output = %q", got)
+	}
+	if *fetches != 2 {
+		t.Errorf("playlist fetched %d times, want 2", *fetches)
+	}
+}
+
+func TestRefreshOn403Bounded(t *testing.T) {
+	// Every URL is refused, including the refreshed ones; each 403 may
+	// trigger one refresh but a refresh must not set off more of its own
+	url, fetches, forbidden := newSignedServer(t, func(int32) bool { return false })
+	_, _, err := downloadString(t, Options{
+		URL:          url,
+		RefreshOn403: 1,
+		Retries:      2,
+		Backoff:      time.Millisecond,
+	})
+	if err == nil {
+		t.Fatal("download succeeded with every segment refused")
+	}
+	if refreshes := *fetches - 1; refreshes > *forbidden {
+		t.Errorf("%d refreshes for %d 403 responses", refreshes, *forbidden)
+	}
+}
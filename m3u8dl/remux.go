@@ -0,0 +1,144 @@
+package m3u8dl
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FindFFmpeg returns the path of ffmpeg on PATH, or an error explaining how to get it
+func FindFFmpeg() (string, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", errors.New("ffmpeg not found on PATH, install it from https://ffmpeg.org to convert to MP4")
+	}
+	return path, nil
+}
+
+// Decide whether the merged TS needs remuxing, moving the merge target to a .ts
+// path so the requested .mp4 name is free for ffmpeg's output
+func (d *Downloader) planRemux() {
+	// fMP4 segments already merge into an MP4 container, and kept segments
+	// aren't merged at all; stdout is rejected by checkOutput
+	if d.initSegment != nil || d.opts.KeepSegments || d.toStdout() {
+		return
+	}
+	ext := filepath.Ext(d.outputFile)
+	if !d.opts.Remux && !strings.EqualFold(ext, ".mp4") {
+		return
+	}
+	base := strings.TrimSuffix(d.outputFile, ext)
+	d.mp4File = base + ".mp4"
+	d.outputFile = base + ".ts"
+}
+
+// Remux converts the merged TS into MP4 with ffmpeg when Options.Remux is set
+// or the output name ends in .mp4, and muxes in a separate audio track fetched
+// by DownloadAudio. The TS is removed unless Options.KeepTS is set.
+func (d *Downloader) Remux(ctx context.Context) error {
+	if d.mp4File == "" && d.audioFile == "" {
+		return nil
+	}
+	if d.opts.KeepSegments {
+		d.emit("warning", map[string]interface{}{"message": "audio kept separately with kept segments", "audio": d.audioFile},
+			"⚠️  Segments are kept unmerged, audio track saved separately as %s\n", d.audioFile)
+		return nil
+	}
+	ffmpeg, err := FindFFmpeg()
+	if err != nil {
+		if d.mp4File == "" {
+			d.emit("warning", map[string]interface{}{"message": "ffmpeg not found, audio kept separately", "audio": d.audioFile},
+				"⚠️  ffmpeg not found, audio track saved separately as %s\n", d.audioFile)
+			return nil
+		}
+		return fmt.Errorf("%w (merged TS kept at %s)", err, d.outputFile)
+	}
+
+	// Muxing audio without converting writes a new file in the same container
+	target := d.mp4File
+	if target == "" {
+		ext := filepath.Ext(d.outputFile)
+		target = strings.TrimSuffix(d.outputFile, ext) + ".muxing" + ext
+	}
+
+	d.emit("remux_start", map[string]interface{}{"input": d.outputFile, "output": target, "audio": d.audioFile},
+		"🎞️  Remuxing with ffmpeg...\n")
+	args := []string{"-y", "-loglevel", "error", "-i", d.outputFile}
+	if len(d.boundaries) > 0 {
+		// Timestamps jump at each discontinuity, so remuxing the file as one
+		// input yields an unseekable MP4; concat the pieces between them instead
+		list, err := d.writeConcatList()
+		if err != nil {
+			return err
+		}
+		args = []string{"-y", "-loglevel", "error", "-f", "concat", "-safe", "0",
+			"-protocol_whitelist", "file,subfile", "-i", list}
+	}
+	if d.audioFile != "" {
+		args = append(args, "-i", d.audioFile, "-map", "0:v", "-map", "1:a")
+	}
+	args = append(args, "-c", "copy", target)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	if d.audioFile != "" {
+		os.Remove(d.audioFile)
+	}
+	if d.mp4File == "" {
+		if err := os.Rename(target, d.outputFile); err != nil {
+			return err
+		}
+	} else {
+		if !d.opts.KeepTS {
+			os.Remove(d.outputFile)
+		}
+		d.outputFile = d.mp4File
+	}
+	d.emit("remuxed", map[string]interface{}{"output": d.outputFile}, "✅ Remuxed into: %s\n", d.outputFile)
+	return nil
+}
+
+// Write an ffmpeg concat list addressing each discontinuity group of the
+// merged TS as a byte range, so no extra copies are needed on disk
+func (d *Downloader) writeConcatList() (string, error) {
+	input, err := filepath.Abs(d.outputFile)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(input)
+	if err != nil {
+		return "", err
+	}
+	input = strings.ReplaceAll(input, "'", `'\''`)
+
+	starts := d.boundaries
+	if starts[0] != 0 {
+		starts = append([]int64{0}, starts...)
+	}
+	var list strings.Builder
+	for i, start := range starts {
+		end := info.Size()
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if end > start {
+			fmt.Fprintf(&list, "file 'subfile,,start,%d,end,%d,,:%s'\n", start, end, input)
+		}
+	}
+
+	path := filepath.Join(d.outputDir, "concat.txt")
+	if err := os.WriteFile(path, []byte(list.String()), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,55 @@
+package m3u8dl
+
+import (
+	"context"
+	"strings"
+)
+
+// Fetch the AES-128 keys a master playlist declares with EXT-X-SESSION-KEY
+// into the key cache, so media playlists naming the same URI reuse them
+// instead of each fetching the key again
+func (d *Downloader) preloadSessionKeys(ctx context.Context, content string) {
+	// A dry run doesn't fetch keys at all
+	if d.opts.DryRun {
+		return
+	}
+	loaded := 0
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-SESSION-KEY:") {
+			continue
+		}
+		attrs := parseAttributes(line)
+		// DRM key systems (KEYFORMAT="com.apple.streamingkeydelivery" etc.)
+		// don't hand out plain keys
+		if attrs["METHOD"] != "AES-128" || attrs["URI"] == "" {
+			continue
+		}
+		if format := attrs["KEYFORMAT"]; format != "" && format != "identity" {
+			continue
+		}
+		// Resolved like EXT-X-KEY URIs, -base-url included, so the cache
+		// entries match
+		base := d.segmentBase()
+		if base == "" && checkResolved(attrs["URI"]) != nil {
+			continue
+		}
+		keyURL := d.resolveURL(base, attrs["URI"])
+		if _, ok := d.keyCache[keyURL]; ok {
+			continue
+		}
+		// A failed preload isn't fatal, EXT-X-KEY fetches the key again with
+		// retries, so one attempt is enough here
+		key, _, err := d.requestKey(ctx, keyURL)
+		if err != nil {
+			d.logger.Warn("session key preload failed", "url", keyURL, "error", err)
+			continue
+		}
+		d.keyCache[keyURL] = key
+		loaded++
+	}
+	if loaded > 0 {
+		d.emit("session_keys", map[string]interface{}{"count": loaded},
+			"🔑 Preloaded %d session key(s) from the master playlist\n", loaded)
+	}
+}
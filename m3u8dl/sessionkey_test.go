@@ -0,0 +1,64 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Master playlist declaring the session key that its media playlist uses
+const sessionKeyMaster = "#EXTM3U\n#EXT-X-SESSION-KEY:METHOD=AES-128,URI=\"key.bin\"\n" +
+	"#EXT-X-STREAM-INF:BANDWIDTH=1000\nvideo.m3u8\n"
+
+func TestSessionKeyWithBaseURL(t *testing.T) {
+	iv := make([]byte, 16)
+	var keyHits int32
+	serveKey := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&keyHits, 1)
+		w.Write(testKey)
+	}
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString(sessionKeyMaster),
+		"/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x00000000000000000000000000000000\n" +
+			"#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+		// Resolving the session key against the master's location would
+		// fetch it from here as well
+		"/key.bin":     serveKey,
+		"/cdn/key.bin": serveKey,
+		"/cdn/0.ts":    serveString(string(encryptSegment(t, []byte("Gcdn"), testKey, iv))),
+	})
+	got, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8", BaseURL: server.URL + "/cdn/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Gcdn" {
+		t.Errorf("output = %q", got)
+	}
+	if keyHits != 1 {
+		t.Errorf("key fetched %d times, want the preloaded one reused", keyHits)
+	}
+}
+
+func TestSessionKeyPreloadNotRetried(t *testing.T) {
+	var keyHits int32
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString(sessionKeyMaster),
+		"/video.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\",IV=0x00000000000000000000000000000000\n" +
+			"#EXTINF:1,\n0.ts\n#EXT-X-ENDLIST\n"),
+		"/key.bin": func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&keyHits, 1)
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		},
+	})
+	_, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8", Retries: 2, Backoff: time.Millisecond})
+	if err == nil {
+		t.Fatal("download succeeded without the key")
+	}
+	// One preload attempt, then EXT-X-KEY's first try and two retries
+	if keyHits != 1+3 {
+		t.Errorf("key requested %d times, want 4", keyHits)
+	}
+}
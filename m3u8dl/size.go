@@ -0,0 +1,65 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var sizeRegex = regexp.MustCompile(`^(\d+(?:\.\d+)?)\s*([KMG]?)B?$`)
+
+// ParseSize parses a byte count like "512MB", "64M" or "1.5GB"; units are
+// powers of 1024
+func ParseSize(value string) (int64, error) {
+	matches := sizeRegex.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if matches == nil {
+		return 0, fmt.Errorf("invalid size %q, expected e.g. 500KB, 512MB or 2GB", value)
+	}
+
+	amount, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %w", value, err)
+	}
+	switch matches[2] {
+	case "K":
+		amount *= 1 << 10
+	case "M":
+		amount *= 1 << 20
+	case "G":
+		amount *= 1 << 30
+	}
+
+	if amount < 1 {
+		return 0, fmt.Errorf("size %q must be at least 1 byte", value)
+	}
+	return int64(amount), nil
+}
+
+// Check an advertised Content-Length against Options.MaxSegmentSize before
+// reading anything. A compressed length below the limit can still inflate
+// past it, which sizeLimitReader catches.
+func (d *Downloader) checkSegmentSize(length int64) error {
+	if d.opts.MaxSegmentSize > 0 && length > d.opts.MaxSegmentSize {
+		return fmt.Errorf("%w: server announced %d bytes, limit is %d", ErrSegmentTooLarge, length, d.opts.MaxSegmentSize)
+	}
+	return nil
+}
+
+// Fails the read once more than max bytes have come through, so an oversized
+// body is never buffered whole
+type sizeLimitReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (s *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n += int64(n)
+	if s.n > s.max {
+		return n, fmt.Errorf("%w: more than %d bytes", ErrSegmentTooLarge, s.max)
+	}
+	return n, err
+}
@@ -0,0 +1,32 @@
+package m3u8dl
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+		ok    bool
+	}{
+		{"512MB", 512 << 20, true},
+		{"64M", 64 << 20, true},
+		{"1.5GB", 3 << 29, true},
+		{"500kb", 500 << 10, true},
+		{" 2 G ", 2 << 30, true},
+		{"2 GB", 2 << 30, true},
+		{"4096", 4096, true},
+		{"100B", 100, true},
+		{"0.5", 0, false},
+		{"0MB", 0, false},
+		{"1TB", 0, false},
+		{"-5MB", 0, false},
+		{"big", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.value)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d (ok %v)", tt.value, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package m3u8dl
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Whether a playlist location is inline data or a file on disk rather than
+// something to fetch over HTTP
+func isLocalPlaylist(location string) bool {
+	return strings.HasPrefix(location, "data:") || strings.HasPrefix(location, "file://") ||
+		!strings.Contains(location, "://")
+}
+
+// Whether a URL or base URL points at an HTTP server
+func isRemote(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Refuse a file:// URI resolved against an HTTP base, which would let a
+// remote playlist read local files
+func checkLocalAccess(base, target string) error {
+	if isRemote(base) && isLocalPlaylist(target) {
+		return fmt.Errorf("refusing to read local file %s named by a remote playlist", target)
+	}
+	return nil
+}
+
+// Read a playlist from a data: URI, a file:// URL or a bare local path
+func readLocalPlaylist(location string) ([]byte, error) {
+	if strings.HasPrefix(location, "data:") {
+		return decodeDataURI(location)
+	}
+	path, err := localPath(location)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+// Decode the payload of a data: URI, e.g.
+// data:application/vnd.apple.mpegurl;base64,I0VYVE0zVQo=
+func decodeDataURI(uri string) ([]byte, error) {
+	comma := strings.Index(uri, ",")
+	if comma < 0 {
+		return nil, fmt.Errorf("malformed data URI: missing ','")
+	}
+	header, payload := uri[len("data:"):comma], uri[comma+1:]
+	if strings.HasSuffix(header, ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			// Some encoders leave the padding off
+			data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("malformed data URI: %w", err)
+		}
+		return data, nil
+	}
+	text, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed data URI: %w", err)
+	}
+	return []byte(text), nil
+}
+
+// File system path of a file:// URL or bare local path
+func localPath(location string) (string, error) {
+	if !strings.HasPrefix(location, "file://") {
+		return location, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", err
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// Base URL for the URIs of a local playlist: -base-url when set, otherwise
+// the file's directory. Inline data has no location of its own, so "".
+func (d *Downloader) localBase(location string) string {
+	if d.opts.BaseURL != "" {
+		return d.opts.BaseURL
+	}
+	if strings.HasPrefix(location, "data:") {
+		return ""
+	}
+	path, err := localPath(location)
+	if err != nil {
+		return ""
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(filepath.Dir(abs)) + "/"}).String()
+}
+
+// Relative URIs from an inline playlist have nothing to resolve against
+func checkResolved(uri string) error {
+	if !strings.Contains(uri, "://") {
+		return fmt.Errorf("can't resolve relative URI %q in an inline playlist, set a base URL with -base-url", uri)
+	}
+	return nil
+}
+
+// Remove user:password@ from an HTTP URL, returning the credentials unless
+// explicit ones were already given
+func splitUserinfo(rawURL, username, password string) (string, string, string) {
+	if isLocalPlaylist(rawURL) {
+		return rawURL, username, password
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL, username, password
+	}
+	if username == "" && password == "" {
+		username = u.User.Username()
+		password, _ = u.User.Password()
+	}
+	u.User = nil
+	return u.String(), username, password
+}
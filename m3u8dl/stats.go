@@ -0,0 +1,58 @@
+package m3u8dl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Stats describes a finished (or failed) DownloadSegments run
+type Stats struct {
+	Bytes          int64         `json:"bytes"`
+	Segments       int           `json:"segments"` // Completed, including ones reused with Resume
+	Failed         int           `json:"failed"`
+	Retries        int           `json:"retries"`
+	Elapsed        time.Duration `json:"elapsed"`       // Wall-clock time spent downloading
+	AverageSpeed   float64       `json:"average_speed"` // Bytes per second over Elapsed
+	PeakSpeed      float64       `json:"peak_speed"`    // Highest rate seen by the progress meter
+	SlowestSegment int           `json:"slowest_segment"`
+	SlowestTime    time.Duration `json:"slowest_time"` // 0 when no segment finished
+}
+
+// Remember the segment that took longest, retries included
+func (d *Downloader) recordSegmentTime(segment *Segment, took time.Duration) {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	if took > d.slowestTime {
+		d.slowestTime = took
+		d.slowestSegment = segment.Index
+	}
+}
+
+// Stats reports the totals of the last DownloadSegments call
+func (d *Downloader) Stats() Stats {
+	d.statsMu.Lock()
+	defer d.statsMu.Unlock()
+	d.speed.mu.Lock()
+	defer d.speed.mu.Unlock()
+
+	s := Stats{
+		Bytes:          atomic.LoadInt64(&d.totalSize),
+		Segments:       int(atomic.LoadInt32(&d.progress)),
+		Retries:        int(atomic.LoadInt32(&d.retriesUsed)),
+		Elapsed:        d.elapsed,
+		PeakSpeed:      d.speed.peak,
+		SlowestSegment: d.slowestSegment,
+		SlowestTime:    d.slowestTime,
+	}
+	if d.elapsed > 0 {
+		s.AverageSpeed = float64(s.Bytes) / d.elapsed.Seconds()
+	}
+	// Downloads shorter than a sample interval never get a full sample
+	if s.PeakSpeed < s.AverageSpeed {
+		s.PeakSpeed = s.AverageSpeed
+	}
+	d.failMu.Lock()
+	s.Failed = len(d.failures)
+	d.failMu.Unlock()
+	return s
+}
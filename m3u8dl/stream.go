@@ -0,0 +1,61 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+)
+
+// Append completed segments to the output file in index order, buffering
+// out-of-order arrivals until the gap before them is filled. Each written
+// segment frees one window slot so another download can start.
+func (d *Downloader) streamSegments(window chan struct{}, cancel context.CancelFunc) error {
+	outFile, err := d.createOutput()
+	if err != nil {
+		cancel()
+		return err
+	}
+	defer outFile.Close()
+
+	writer := bufio.NewWriter(outFile)
+
+	if err := d.writeInit(writer); err != nil {
+		cancel()
+		return err
+	}
+
+	pending := make(map[int]*Segment)
+	next := 0
+	var written int64
+	for segment := range d.downloadedCh {
+		pending[segment.Index] = segment
+		for {
+			seg, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if seg.Discontinuity {
+				d.boundaries = append(d.boundaries, written)
+			}
+			n, err := writer.Write(seg.data)
+			if err != nil {
+				cancel()
+				return fmt.Errorf("failed to write segment %d: %w", seg.Index, err)
+			}
+			written += int64(n)
+			seg.data = nil
+			next++
+			<-window
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	d.streamed = next
+	if next == len(d.segments) {
+		d.emit("merged", map[string]interface{}{"output": d.outputFile}, "\n✅ Streamed into: %s\n", d.outputFile)
+	}
+	return nil
+}
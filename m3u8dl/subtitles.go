@@ -0,0 +1,248 @@
+package m3u8dl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	vttTimestampRegex = regexp.MustCompile(`(?:(\d+):)?(\d{2}):(\d{2})\.(\d{3})`)
+	timestampMapRegex = regexp.MustCompile(`MPEGTS:(\d+)|LOCAL:([0-9:.]+)`)
+)
+
+// Remember the subtitle rendition in the requested language, if the master playlist has one
+func (d *Downloader) pickSubtitles(media []Media, variant Variant) {
+	if d.opts.Subtitles == "" {
+		return
+	}
+	// Subtitles are saved next to the output, which stdout doesn't have
+	if d.toStdout() {
+		d.emit("warning", map[string]interface{}{"message": "subtitles aren't saved when writing to stdout"},
+			"⚠️  Subtitles aren't saved when the video goes to stdout\n")
+		return
+	}
+
+	var available []string
+	for i := range media {
+		m := &media[i]
+		if m.Type != "SUBTITLES" || m.URI == "" {
+			continue
+		}
+		// Prefer the variant's own group, but any group will do without one
+		if variant.Subtitles != "" && m.GroupID != variant.Subtitles {
+			continue
+		}
+		if strings.EqualFold(m.Language, d.opts.Subtitles) ||
+			strings.HasPrefix(strings.ToLower(m.Language), strings.ToLower(d.opts.Subtitles)+"-") {
+			d.subtitles = m
+			d.emit("subtitles_selected", map[string]interface{}{"language": m.Language, "name": m.Name, "url": m.URI},
+				"💬 Subtitles: %s\n", mediaLabel(m))
+			return
+		}
+		available = append(available, mediaLabel(m))
+	}
+
+	if len(available) == 0 {
+		d.emit("warning", map[string]interface{}{"message": "no subtitles in the playlist"},
+			"⚠️  Playlist has no subtitles, ignoring -subs %s\n", d.opts.Subtitles)
+		return
+	}
+	d.emit("warning", map[string]interface{}{"message": "requested subtitles not found", "language": d.opts.Subtitles, "available": available},
+		"⚠️  No %q subtitles, available: %s\n", d.opts.Subtitles, strings.Join(available, ", "))
+}
+
+// DownloadSubtitles fetches the subtitle rendition picked for Options.Subtitles,
+// if any, and joins its WebVTT segments into a .vtt file next to the output
+func (d *Downloader) DownloadSubtitles(ctx context.Context) error {
+	if d.subtitles == nil {
+		return nil
+	}
+
+	opts := d.childOptions()
+	opts.URL = d.subtitles.URI
+	opts.TempDir = filepath.Join(d.outputDir, "subs")
+	opts.OutputFile = ""
+	opts.Stream = false
+	opts.Remux = false
+	// Each WebVTT segment is read back on its own when merging
+	opts.PipelineMerge = false
+	opts.Checksums = nil // Listed for the video segments only
+	// Match the video's time span, not its segment positions
+	opts.StartSegment, opts.EndSegment = 0, 0
+	opts.StartTime, opts.EndTime = d.rangeStart, d.rangeEnd
+	opts.SkipTSCheck = true
+	opts.Audio, opts.Subtitles = "", ""
+	sub := NewDownloader(opts)
+	sub.client = d.client
+	sub.limiter = d.limiter
+
+	d.emit("subtitles_start", map[string]interface{}{"url": d.subtitles.URI}, "\n💬 Downloading subtitles...\n")
+	if err := sub.parsePlaylist(ctx, make(map[string]bool)); err != nil {
+		return fmt.Errorf("subtitles: %w", err)
+	}
+	if err := sub.DownloadSegments(ctx); err != nil {
+		return fmt.Errorf("subtitles: %w", err)
+	}
+
+	lang := d.subtitles.Language
+	if lang == "" {
+		lang = d.opts.Subtitles
+	}
+	vttFile := strings.TrimSuffix(d.outputFile, filepath.Ext(d.outputFile)) + "." + lang + ".vtt"
+	if err := sub.mergeWebVTT(vttFile); err != nil {
+		return fmt.Errorf("subtitles: %w", err)
+	}
+	d.subtitlesFile = vttFile
+	d.emit("subtitles_saved", map[string]interface{}{"output": vttFile}, "✅ Subtitles saved to: %s\n", vttFile)
+	return nil
+}
+
+// Join downloaded WebVTT segments into one file. Each segment carries its own
+// header; cue times are shifted onto a common timeline using X-TIMESTAMP-MAP,
+// or the segment's start time when cues are segment-relative.
+func (d *Downloader) mergeWebVTT(path string) error {
+	outFile, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	writer := bufio.NewWriter(outFile)
+	writer.WriteString("WEBVTT\n")
+
+	var (
+		start   time.Duration      // Playlist time at which the current segment starts
+		baseTS  int64         = -1 // MPEGTS of the first mapped segment
+		written bool
+	)
+	for _, segment := range d.segments {
+		segmentStart := start
+		start += time.Duration(segment.Duration * float64(time.Second))
+		// Segments stay on disk until Cleanup removes the temp dir
+		data, err := os.ReadFile(d.segmentPath(segment))
+		if err != nil {
+			// Failed segments leave a gap in the cues when continuing past errors
+			if d.opts.ContinueOnError && d.segmentFailed(segment.Index) {
+				d.emit("warning", map[string]interface{}{"message": "subtitle segment missing", "segment": segment.Index},
+					"⚠️  Subtitle segment %d is missing, its cues are left out\n", segment.Index)
+				continue
+			}
+			return fmt.Errorf("failed to open segment %d: %w", segment.Index, err)
+		}
+
+		header, body := splitWebVTT(string(data))
+		offset := time.Duration(0)
+		if mpegts, local, ok := parseTimestampMap(header); ok {
+			if baseTS < 0 {
+				baseTS = mpegts
+			}
+			offset = time.Duration(mpegts-baseTS)*time.Second/90000 - local
+		} else if first, ok := firstCueStart(body); ok && first+time.Second < segmentStart {
+			// Cues restart at zero in every segment
+			offset = segmentStart
+		}
+
+		if strings.TrimSpace(body) == "" {
+			continue
+		}
+		writer.WriteString("\n")
+		writer.WriteString(shiftCues(body, offset))
+		written = true
+	}
+	if !written {
+		d.emit("warning", map[string]interface{}{"message": "subtitle track has no cues"},
+			"⚠️  Subtitle track contains no cues\n")
+	}
+	return writer.Flush()
+}
+
+// Split a WebVTT document into its header block and the cues after it
+func splitWebVTT(doc string) (string, string) {
+	doc = strings.ReplaceAll(strings.TrimPrefix(doc, "\uFEFF"), "\r\n", "\n")
+	if i := strings.Index(doc, "\n\n"); i >= 0 {
+		return doc[:i], strings.TrimLeft(doc[i+2:], "\n")
+	}
+	return doc, ""
+}
+
+// Read X-TIMESTAMP-MAP=MPEGTS:<ticks>,LOCAL:<time> from a WebVTT header
+func parseTimestampMap(header string) (int64, time.Duration, bool) {
+	for _, line := range strings.Split(header, "\n") {
+		if !strings.HasPrefix(line, "X-TIMESTAMP-MAP=") {
+			continue
+		}
+		var (
+			mpegts int64
+			local  time.Duration
+			found  bool
+		)
+		for _, match := range timestampMapRegex.FindAllStringSubmatch(line, -1) {
+			if match[1] != "" {
+				mpegts, _ = strconv.ParseInt(match[1], 10, 64)
+				found = true
+			} else if t, ok := parseVTTTime(match[2]); ok {
+				local = t
+			}
+		}
+		return mpegts, local, found
+	}
+	return 0, 0, false
+}
+
+// Start time of the first cue in a block of cues
+func firstCueStart(body string) (time.Duration, bool) {
+	for _, line := range strings.Split(body, "\n") {
+		if strings.Contains(line, "-->") {
+			return parseVTTTime(strings.TrimSpace(strings.SplitN(line, "-->", 2)[0]))
+		}
+	}
+	return 0, false
+}
+
+// Shift every cue timing line by offset
+func shiftCues(body string, offset time.Duration) string {
+	if offset == 0 {
+		return body
+	}
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, "-->") {
+			continue
+		}
+		lines[i] = vttTimestampRegex.ReplaceAllStringFunc(line, func(ts string) string {
+			t, _ := parseVTTTime(ts)
+			return formatVTTTime(t + offset)
+		})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Parse a WebVTT timestamp, "[hh:]mm:ss.ttt"
+func parseVTTTime(value string) (time.Duration, bool) {
+	match := vttTimestampRegex.FindStringSubmatch(value)
+	if match == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(match[1])
+	minutes, _ := strconv.Atoi(match[2])
+	seconds, _ := strconv.Atoi(match[3])
+	millis, _ := strconv.Atoi(match[4])
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond, true
+}
+
+// Format a duration as a WebVTT timestamp, clamping negative times to zero
+func formatVTTTime(t time.Duration) string {
+	if t < 0 {
+		t = 0
+	}
+	ms := t.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
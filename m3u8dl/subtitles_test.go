@@ -0,0 +1,88 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseVTTTime(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"00:01.500", 1500 * time.Millisecond, true},
+		{"12:34.567", 12*time.Minute + 34567*time.Millisecond, true},
+		{"01:02:03.004", time.Hour + 2*time.Minute + 3004*time.Millisecond, true},
+		{"100:00:00.000", 100 * time.Hour, true},
+		{"1:02.003", 0, false},
+		{"00:01,500", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseVTTTime(tt.value)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseVTTTime(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestShiftCues(t *testing.T) {
+	body := "WEBVTT\n\n1\n00:01.000 --> 00:04.500 align:start\nAt 00:02.000 the text stays\n\n" +
+		"59:59.000 --> 01:00:01.250\nLate cue\n"
+	tests := []struct {
+		offset time.Duration
+		want   string
+	}{
+		{0, body},
+		{90 * time.Second, "WEBVTT\n\n1\n00:01:31.000 --> 00:01:34.500 align:start\nAt 00:02.000 the text stays\n\n" +
+			"01:01:29.000 --> 01:01:31.250\nLate cue\n"},
+		// Cues before the new start clamp to zero
+		{-2 * time.Second, "WEBVTT\n\n1\n00:00:00.000 --> 00:00:02.500 align:start\nAt 00:02.000 the text stays\n\n" +
+			"00:59:57.000 --> 00:59:59.250\nLate cue\n"},
+	}
+	for _, tt := range tests {
+		if got := shiftCues(body, tt.offset); got != tt.want {
+			t.Errorf("shiftCues(%v) =\n%s\nwant\n%s", tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestMergeWebVTTSkipsFailedSegment(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID=\"subs\",LANGUAGE=\"en\",NAME=\"English\",URI=\"subs.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=1000,SUBTITLES=\"subs\"\nvideo.m3u8\n"),
+		"/video.m3u8": serveString("#EXTM3U\n#EXTINF:6,\n0.ts\n#EXT-X-ENDLIST\n"),
+		"/subs.m3u8": serveString("#EXTM3U\n#EXTINF:2,\n0.vtt\n#EXTINF:2,\nmissing.vtt\n" +
+			"#EXTINF:2,\n2.vtt\n#EXT-X-ENDLIST\n"),
+		"/0.ts": serveContent("Gvideo"),
+		// Cues restart at zero in every segment
+		"/0.vtt":       serveContent("WEBVTT\n\n00:00.500 --> 00:01.000\nFirst\n"),
+		"/missing.vtt": func(w http.ResponseWriter, r *http.Request) { http.Error(w, "gone", http.StatusNotFound) },
+		"/2.vtt":       serveContent("WEBVTT\n\n00:00.500 --> 00:01.000\nThird\n"),
+	})
+	opts := Options{URL: server.URL + "/master.m3u8", Subtitles: "en", Retries: -1}
+	if _, _, err := downloadString(t, opts); err == nil {
+		t.Error("subtitles merged with a missing segment without ContinueOnError")
+	}
+
+	output := filepath.Join(t.TempDir(), "out.ts")
+	opts.OutputFile = output
+	opts.ContinueOnError = true
+	if _, _, err := downloadString(t, opts); err != nil {
+		t.Fatal(err)
+	}
+	vtt, err := os.ReadFile(strings.TrimSuffix(output, ".ts") + ".en.vtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The third segment keeps its place on the timeline despite the gap
+	if !strings.Contains(string(vtt), "First") || !strings.Contains(string(vtt), "00:00:04.500 --> 00:00:05.000\nThird") {
+		t.Errorf("subtitles =\n%s", vtt)
+	}
+}
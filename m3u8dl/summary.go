@@ -0,0 +1,34 @@
+package m3u8dl
+
+import "time"
+
+// Summary describes a parsed playlist without downloading any media
+type Summary struct {
+	URL       string        `json:"url"` // Media playlist, after resolving a master playlist
+	Segments  int           `json:"segments"`
+	Duration  time.Duration `json:"duration"`
+	Encrypted bool          `json:"encrypted"`
+	KeyMethod string        `json:"key_method,omitempty"` // e.g. "AES-128"; empty when unencrypted
+	Container string        `json:"container"`            // ContainerTS, ContainerFMP4, ContainerAAC or ContainerMP3
+	StartTime time.Time     `json:"start_time"`           // From EXT-X-PROGRAM-DATE-TIME; zero when absent
+}
+
+// Summary reports what ParseM3U8 found
+func (d *Downloader) Summary() Summary {
+	s := Summary{
+		URL:       d.m3u8URL,
+		Segments:  len(d.segments),
+		Duration:  d.totalDuration,
+		KeyMethod: d.keyMethod,
+		Container: d.container,
+		StartTime: d.StartTime(),
+	}
+	s.Encrypted = s.KeyMethod != ""
+	// Nothing was detected, e.g. before parsing or with unknown extensions
+	if s.Container == "" && d.initSegment != nil {
+		s.Container = ContainerFMP4
+	} else if s.Container == "" {
+		s.Container = ContainerTS
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Headers whose values are credentials and never traced
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// Round tripper that writes each request and its response to Options.Trace
+// in the style of curl -v, numbered so concurrent requests can be told apart
+type tracingTransport struct {
+	next http.RoundTripper
+	mu   sync.Mutex
+	w    io.Writer
+	seq  int64
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := atomic.AddInt64(&t.seq, 1)
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%d] > %s %s\n", id, req.Method, req.URL.Redacted())
+	keys := make([]string, 0, len(req.Header))
+	for key := range req.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range req.Header[key] {
+			if redactedHeaders[http.CanonicalHeaderKey(key)] {
+				value = "[redacted]"
+			}
+			fmt.Fprintf(&b, "[%d] > %s: %s\n", id, key, value)
+		}
+	}
+	t.write(b.String())
+
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		t.write(fmt.Sprintf("[%d] ! %v (%s)\n", id, err, elapsed))
+		return resp, err
+	}
+	length := "unknown"
+	if resp.ContentLength >= 0 {
+		length = fmt.Sprint(resp.ContentLength)
+	}
+	t.write(fmt.Sprintf("[%d] < %s, Content-Length: %s, Content-Type: %s (%s)\n",
+		id, resp.Status, length, resp.Header.Get("Content-Type"), elapsed))
+	return resp, nil
+}
+
+// Write one request's lines without interleaving them with another's
+func (t *tracingTransport) write(s string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	io.WriteString(t.w, s)
+}
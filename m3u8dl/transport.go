@@ -0,0 +1,106 @@
+package m3u8dl
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// How long an unused keep-alive connection stays in the pool
+const idleConnTimeout = 90 * time.Second
+
+// Build the transport shared by playlist, key and segment requests
+func newTransport(opts Options) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	} else if opts.RootCAs != nil {
+		transport.TLSClientConfig = &tls.Config{RootCAs: opts.RootCAs}
+	}
+
+	// The default keeps only 2 idle connections per host, so with more
+	// workers against one CDN host most requests would open a fresh
+	// connection (and TLS handshake) instead of reusing one
+	conns := opts.ConnsPerHost
+	if conns <= 0 {
+		conns = opts.Workers
+	}
+	transport.MaxConnsPerHost = conns
+	transport.MaxIdleConnsPerHost = conns
+	if transport.MaxIdleConns < conns {
+		transport.MaxIdleConns = conns
+	}
+	transport.IdleConnTimeout = idleConnTimeout
+	transport.Proxy = newProxyFunc(opts)
+	if opts.DNS != "" || opts.PreferIPv4 {
+		transport.DialContext = newDialer(opts)
+	}
+	// Segments of a playlist read from disk are files too. Only then, so a
+	// remote playlist can't name local files as segments or keys.
+	if isLocalPlaylist(opts.URL) {
+		transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	}
+	return transport
+}
+
+// Dial function for a custom DNS server and/or IPv4-first connections, for
+// networks with broken IPv6 or a captive resolver
+func newDialer(opts Options) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	// Same settings as http.DefaultTransport's dialer
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if opts.DNS != "" {
+		server := DNSServer(opts.DNS)
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	if !opts.PreferIPv4 {
+		return dialer.DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			conn, err := dialer.DialContext(ctx, "tcp4", addr)
+			// Hosts with only IPv6 addresses still get through
+			if err == nil || ctx.Err() != nil {
+				return conn, err
+			}
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// LoadCACerts returns the system roots plus the PEM certificates in each file,
+// for servers signed by a corporate or private CA
+func LoadCACerts(paths []string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", path)
+		}
+	}
+	return pool, nil
+}
+
+// DNSServer adds the default port to a DNS server address given without one
+func DNSServer(addr string) string {
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(addr, "53")
+}
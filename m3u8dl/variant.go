@@ -0,0 +1,181 @@
+package m3u8dl
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Variant is one #EXT-X-STREAM-INF entry of a master playlist
+type Variant struct {
+	URL       string `json:"url"`
+	Bandwidth int64  `json:"bandwidth"`
+	Width     int    `json:"width,omitempty"` // 0 when RESOLUTION is absent
+	Height    int    `json:"height,omitempty"`
+	Codecs    string `json:"codecs,omitempty"`
+	Audio     string `json:"audio,omitempty"` // GROUP-ID of the EXT-X-MEDIA audio renditions it plays with
+	Subtitles string `json:"subtitles,omitempty"`
+}
+
+var (
+	bandwidthRegex  = regexp.MustCompile(`(?:^|[:,])BANDWIDTH=(\d+)`)
+	resolutionRegex = regexp.MustCompile(`RESOLUTION=(\d+)x(\d+)`)
+	codecsRegex     = regexp.MustCompile(`CODECS="([^"]*)"`)
+	audioGroupRegex = regexp.MustCompile(`AUDIO="([^"]*)"`)
+	subsGroupRegex  = regexp.MustCompile(`SUBTITLES="([^"]*)"`)
+	iframeURIRegex  = regexp.MustCompile(`(?:^|[:,])URI="([^"]+)"`)
+)
+
+// Resolution returns the variant size as "WxH", or "" when unknown
+func (v Variant) Resolution() string {
+	if v.Width == 0 || v.Height == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%dx%d", v.Width, v.Height)
+}
+
+// ParseResolution parses "1280x720", "720p" or "720" into a width and height
+// limit; width is 0 when only a height was given
+func ParseResolution(value string) (int, int, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if parts := strings.SplitN(value, "x", 2); len(parts) == 2 {
+		width, errW := strconv.Atoi(parts[0])
+		height, errH := strconv.Atoi(parts[1])
+		if errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return 0, 0, fmt.Errorf("invalid resolution %q, expected WIDTHxHEIGHT", value)
+		}
+		return width, height, nil
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(value, "p"))
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid resolution %q, expected WIDTHxHEIGHT or e.g. 720p", value)
+	}
+	return 0, height, nil
+}
+
+// Parse all variant streams from a master playlist. EXT-X-I-FRAME-STREAM-INF
+// trick-play streams hold only keyframes, so they are left out, see
+// parseIFrameVariants.
+func (d *Downloader) parseVariants(content string) []Variant {
+	lines := strings.Split(content, "\n")
+	baseURL := d.playlistBase()
+	var variants []Variant
+
+	for i, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "#EXT-X-STREAM-INF") {
+			continue
+		}
+
+		variant := parseVariantAttributes(line)
+
+		// Get next non-empty line (variant URL)
+		for _, next := range lines[i+1:] {
+			next = strings.TrimSpace(next)
+			if next == "" {
+				continue
+			}
+			if !strings.HasPrefix(next, "#") {
+				variant.URL = d.resolveURL(baseURL, next)
+			}
+			break
+		}
+		if variant.URL != "" {
+			variants = append(variants, variant)
+		}
+	}
+
+	return variants
+}
+
+// Parse the EXT-X-I-FRAME-STREAM-INF trick-play streams of a master playlist,
+// which carry their playlist in a URI attribute instead of the next line
+func (d *Downloader) parseIFrameVariants(content string) []Variant {
+	baseURL := d.playlistBase()
+	var variants []Variant
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "#EXT-X-I-FRAME-STREAM-INF") {
+			continue
+		}
+		variant := parseVariantAttributes(line)
+		if matches := iframeURIRegex.FindStringSubmatch(line); len(matches) > 1 {
+			variant.URL = d.resolveURL(baseURL, matches[1])
+			variants = append(variants, variant)
+		}
+	}
+	return variants
+}
+
+// Bandwidth, resolution, codecs and rendition groups of a variant tag
+func parseVariantAttributes(line string) Variant {
+	variant := Variant{}
+	if matches := bandwidthRegex.FindStringSubmatch(line); len(matches) > 1 {
+		variant.Bandwidth, _ = strconv.ParseInt(matches[1], 10, 64)
+	}
+	if matches := resolutionRegex.FindStringSubmatch(line); len(matches) > 2 {
+		variant.Width, _ = strconv.Atoi(matches[1])
+		variant.Height, _ = strconv.Atoi(matches[2])
+	}
+	if matches := codecsRegex.FindStringSubmatch(line); len(matches) > 1 {
+		variant.Codecs = matches[1]
+	}
+	if matches := audioGroupRegex.FindStringSubmatch(line); len(matches) > 1 {
+		variant.Audio = matches[1]
+	}
+	if matches := subsGroupRegex.FindStringSubmatch(line); len(matches) > 1 {
+		variant.Subtitles = matches[1]
+	}
+	return variant
+}
+
+// Extract best quality variant from master playlist
+func (d *Downloader) extractBestVariant(content string) (Variant, error) {
+	d.variants = d.parseVariants(content)
+	d.iframeVariants = d.parseIFrameVariants(content)
+	if len(d.variants) == 0 {
+		return Variant{}, fmt.Errorf("no variant found in master playlist")
+	}
+
+	best, fits := selectVariant(d.variants, d.opts.MaxWidth, d.opts.MaxHeight, d.opts.MaxBandwidth)
+	if !fits {
+		d.emit("warning", map[string]interface{}{"message": "no variant satisfies the quality limits"},
+			"⚠️  No variant satisfies the quality limits, using the lowest one\n")
+	}
+	if best.Resolution() != "" {
+		d.emit("variant_selected", map[string]interface{}{"resolution": best.Resolution(), "bandwidth": best.Bandwidth},
+			"📐 Selected %s @ %d bps\n", best.Resolution(), best.Bandwidth)
+	}
+	return best, nil
+}
+
+// Pick the highest-bandwidth variant within the limits (0 means unlimited).
+// When nothing fits, fall back to the lowest-bandwidth variant, the closest
+// one to the requested ceiling, and report false.
+func selectVariant(variants []Variant, maxWidth, maxHeight int, maxBandwidth int64) (Variant, bool) {
+	var best, lowest *Variant
+	for i := range variants {
+		v := &variants[i]
+		if lowest == nil || v.Bandwidth < lowest.Bandwidth {
+			lowest = v
+		}
+
+		if maxBandwidth > 0 && v.Bandwidth > maxBandwidth {
+			continue
+		}
+		if maxWidth > 0 && v.Width > maxWidth {
+			continue
+		}
+		if maxHeight > 0 && v.Height > maxHeight {
+			continue
+		}
+		if best == nil || v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+	}
+
+	if best == nil {
+		return *lowest, false
+	}
+	return *best, true
+}
@@ -0,0 +1,44 @@
+package m3u8dl
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestIFrameVariantsExcluded(t *testing.T) {
+	server := newTestServer(t, map[string]http.HandlerFunc{
+		// The trick-play stream advertises the highest bandwidth
+		"/master.m3u8": serveString("#EXTM3U\n" +
+			"#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=9000000,RESOLUTION=1920x1080,URI=\"iframe.m3u8\"\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360\nlow.m3u8\n" +
+			"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720\nhigh.m3u8\n"),
+		"/iframe.m3u8": serveString("#EXTM3U\n#EXT-X-I-FRAMES-ONLY\n#EXTINF:1,\niframe.ts\n#EXT-X-ENDLIST\n"),
+		"/low.m3u8":    serveString("#EXTM3U\n#EXTINF:1,\nlow.ts\n#EXT-X-ENDLIST\n"),
+		"/high.m3u8":   serveString("#EXTM3U\n#EXTINF:1,\nhigh.ts\n#EXT-X-ENDLIST\n"),
+		"/iframe.ts":   serveString("Giframe"),
+		"/low.ts":      serveString("Glow"),
+		"/high.ts":     serveString("Ghigh"),
+	})
+
+	d := parseURL(t, server.URL+"/master.m3u8")
+	if n := len(d.Variants()); n != 2 {
+		t.Errorf("%d variants, want 2", n)
+	}
+	for _, v := range d.Variants() {
+		if v.Bandwidth == 9000000 {
+			t.Errorf("I-frame stream listed as a variant: %+v", v)
+		}
+	}
+	iframes := d.IFrameVariants()
+	if len(iframes) != 1 || iframes[0].URL != server.URL+"/iframe.m3u8" || iframes[0].Height != 1080 {
+		t.Errorf("IFrameVariants = %+v", iframes)
+	}
+
+	got, _, err := downloadString(t, Options{URL: server.URL + "/master.m3u8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Ghigh" {
+		t.Errorf("output = %q, want the best regular variant", got)
+	}
+}
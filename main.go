@@ -0,0 +1,1004 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vizshrc/m3u8-downloader/m3u8dl"
+)
+
+// Repeatable string flag collecting every occurrence
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Report whether a flag was explicitly passed on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Emit a CLI status event with a printf-style human message
+func report(out m3u8dl.EventWriter, name string, fields map[string]interface{}, format string, args ...interface{}) {
+	out.Emit(m3u8dl.Event{Name: name, Message: fmt.Sprintf(format, args...), Fields: fields})
+}
+
+// Exit statuses, so scripts can tell which stage failed
+const (
+	exitFailure     = 1   // Setup failed (checksums, cookies, ffmpeg, temp directory)
+	exitUsage       = 2   // Invalid flags, as the flag package uses
+	exitParse       = 3   // The playlist couldn't be fetched or parsed
+	exitDownload    = 4   // Segments or the audio track failed to download
+	exitMerge       = 5   // Merging or remuxing failed
+	exitBatch       = 6   // Some -batch downloads failed
+	exitHook        = 7   // The -on-complete command failed
+	exitInterrupted = 130 // Stopped with Ctrl-C
+)
+
+// Error that run has already reported, with the status to exit with
+type exitError struct {
+	code    int
+	message string
+}
+
+func (e *exitError) Error() string {
+	return e.message
+}
+
+// Emit an error event, returning it as the error run exits with
+func reportError(out m3u8dl.EventWriter, code int, format string, args ...interface{}) error {
+	message := fmt.Sprintf(format, args...)
+	report(out, "error", map[string]interface{}{"message": message}, "❌ %s\n", message)
+	return &exitError{code: code, message: message}
+}
+
+// Exit status for a failed stage, unless Ctrl-C is what stopped it
+func stageCode(ctx context.Context, code int) int {
+	if ctx.Err() != nil {
+		return exitInterrupted
+	}
+	return code
+}
+
+// Print the variants of a master playlist as a table, followed by its
+// I-frame streams, which are listed for reference but never downloaded
+func printVariants(out m3u8dl.EventWriter, variants, iframes []m3u8dl.Variant) {
+	if len(variants) == 0 {
+		report(out, "variants", map[string]interface{}{"variants": variants}, "ℹ️  Not a master playlist, there is only one stream\n")
+		return
+	}
+
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+	writeVariantRows(w, variants)
+	if len(iframes) > 0 {
+		fmt.Fprintln(w, "\nI-frame (trick play) streams, not downloadable:")
+		writeVariantRows(w, iframes)
+	}
+	w.Flush()
+	report(out, "variants", map[string]interface{}{"variants": variants, "iframe_variants": iframes}, "%s", table.String())
+}
+
+// Write a header and one numbered row per variant
+func writeVariantRows(w io.Writer, variants []m3u8dl.Variant) {
+	fmt.Fprintln(w, "#\tBANDWIDTH\tRESOLUTION\tCODECS\tURL")
+	for i, v := range variants {
+		resolution := v.Resolution()
+		if resolution == "" {
+			resolution = "-"
+		}
+		codecs := v.Codecs
+		if codecs == "" {
+			codecs = "-"
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\n", i+1, v.Bandwidth, resolution, codecs, v.URL)
+	}
+}
+
+// Print what a dry run found
+func printSummary(out m3u8dl.EventWriter, s m3u8dl.Summary) {
+	encryption := "none"
+	if s.Encrypted {
+		encryption = s.KeyMethod
+		if s.KeyMethod != "AES-128" {
+			encryption += " (unsupported)"
+		}
+	}
+	fields := map[string]interface{}{
+		"url": s.URL, "segments": s.Segments, "duration": s.Duration.Seconds(),
+		"encrypted": s.Encrypted, "key_method": s.KeyMethod, "container": s.Container,
+	}
+	started := ""
+	if !s.StartTime.IsZero() {
+		fields["start_time"] = s.StartTime.Format(time.RFC3339Nano)
+		started = fmt.Sprintf("   Starts at:  %s\n", s.StartTime.Format("2006-01-02 15:04:05.000 MST"))
+	}
+	report(out, "summary", fields, "\n📋 Dry run summary\n   Playlist:   %s\n   Segments:   %d\n   Duration:   %s\n   Encryption: %s\n   Container:  %s\n%s",
+		s.URL, s.Segments, s.Duration.Round(time.Second), encryption, s.Container, started)
+}
+
+// Print what a decryption test used and whether the result looks like media
+func printDecryptTest(out m3u8dl.EventWriter, t m3u8dl.DecryptTest) {
+	ivSource := "from the playlist"
+	if t.IVFromSeq {
+		ivSource = "from the media sequence number"
+	}
+	verdict := fmt.Sprintf("✅ Decrypted data looks like %s\n", t.Container)
+	switch {
+	case t.Error != "":
+		verdict = fmt.Sprintf("❌ Decryption failed: %s (wrong key or IV?)\n", t.Error)
+	case t.Container == "":
+		verdict = "❌ Decrypted data isn't MPEG-TS, fMP4 or audio (wrong key or IV?)\n"
+	}
+	report(out, "decrypt_test", map[string]interface{}{
+		"segment": t.Segment, "url": t.URL, "method": t.Method, "key_length": t.KeyLength, "iv": t.IV,
+		"iv_from_sequence": t.IVFromSeq, "bytes": t.Bytes, "container": t.Container, "error": t.Error, "ok": t.OK(),
+	}, "\n🔐 Decryption test on segment %d\n   Method:    %s\n   Key:       %d bytes\n   IV:        %s (%s)\n"+
+		"   Encrypted: %d bytes\n%s", t.Segment, t.Method, t.KeyLength, t.IV, ivSource, t.Bytes, verdict)
+}
+
+// Print the totals of a finished download
+func printStats(out m3u8dl.EventWriter, s m3u8dl.Stats) {
+	slowest := "-"
+	if s.SlowestTime > 0 {
+		slowest = fmt.Sprintf("#%d in %s", s.SlowestSegment, s.SlowestTime.Round(time.Millisecond))
+	}
+	report(out, "stats", map[string]interface{}{
+		"bytes": s.Bytes, "segments": s.Segments, "failed": s.Failed, "retries": s.Retries,
+		"seconds": s.Elapsed.Seconds(), "average_speed": int64(s.AverageSpeed), "peak_speed": int64(s.PeakSpeed),
+		"slowest_segment": s.SlowestSegment, "slowest_seconds": s.SlowestTime.Seconds(),
+	}, "\n📊 Statistics\n   Downloaded: %.2f MB in %s\n   Speed:      %.2f MB/s average, %.2f MB/s peak\n"+
+		"   Segments:   %d done, %d failed, %d retries\n   Slowest:    %s\n",
+		float64(s.Bytes)/(1<<20), s.Elapsed.Round(time.Millisecond), s.AverageSpeed/(1<<20), s.PeakSpeed/(1<<20),
+		s.Segments, s.Failed, s.Retries, slowest)
+}
+
+// Create a temp directory of our own, m3u8_temp_<url hash>_<random>, so
+// concurrent runs never share one. With -resume the newest directory left by
+// an earlier run of the same URL is reused instead.
+func makeTempDir(parent, playlistURL string, resume bool) (string, error) {
+	urlHash := sha256.Sum256([]byte(playlistURL))
+	prefix := "m3u8_temp_" + hex.EncodeToString(urlHash[:8])
+	if resume {
+		if dir := latestTempDir(parent, prefix); dir != "" {
+			return dir, nil
+		}
+	}
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(parent, prefix+"_")
+}
+
+// Most recently modified directory in parent whose name starts with prefix
+func latestTempDir(parent, prefix string) string {
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		return ""
+	}
+	var (
+		latest   string
+		modified time.Time
+	)
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if latest == "" || info.ModTime().After(modified) {
+			latest, modified = filepath.Join(parent, entry.Name()), info.ModTime()
+		}
+	}
+	return latest
+}
+
+func main() {
+	if err := run(); err != nil {
+		code := exitFailure
+		var exit *exitError
+		if errors.As(err, &exit) {
+			code = exit.code
+		}
+		os.Exit(code)
+	}
+}
+
+// Run the CLI, returning once deferred cleanup is done so main can set the
+// exit status
+func run() error {
+	m3u8URL := flag.String("url", "", "M3U8 playlist URL, local file or data: URI")
+	batch := flag.String("batch", "", "File of \"url [output]\" lines to download one after another")
+	baseURL := flag.String("base-url", "", "Base URL for relative segment and key URIs instead of the playlist's location")
+	outputFile := flag.String("output", "output.ts", "Output file path")
+	force := flag.Bool("force", false, "Overwrite an existing output file")
+	workers := flag.Int("workers", m3u8dl.DefaultWorkers, "Number of concurrent downloads")
+	stream := flag.Bool("stream", false, "Write segments straight into the output without temp files")
+	streamBuffer := flag.Int("stream-buffer", 0, "Segments -stream holds in memory ahead of the disk (default: 2 x workers)")
+	pipelineMerge := flag.Bool("pipeline-merge", false, "Write segments into a preallocated output as they finish")
+	resume := flag.Bool("resume", false, "Resume an interrupted download of the same URL")
+	mergeOnInterrupt := flag.Bool("merge-on-interrupt", false, "On Ctrl-C, merge the segments finished so far into the output")
+	keepTemp := flag.Bool("keep-temp", false, "Keep the temp directory and its segments after the download")
+	keepTempOnError := flag.Bool("keep-temp-on-error", false, "Keep the temp directory when the download fails")
+	live := flag.Bool("live", false, "Keep reloading a live playlist until it ends")
+	seqNames := flag.Bool("seq-names", false, "Name segment files by media sequence number instead of position")
+	dedupe := flag.Bool("dedupe", false, "Download segments repeated in the playlist only once")
+	resolution := flag.String("resolution", "", "Highest variant resolution to pick, e.g. 1280x720 or 720p")
+	maxBandwidth := flag.Int64("max-bandwidth", 0, "Highest variant bandwidth to pick in bits/s")
+	listVariants := flag.Bool("list-variants", false, "List the qualities of a master playlist and exit")
+	startSegment := flag.Int("start-segment", 0, "First segment to download, counting from 0")
+	endSegment := flag.Int("end-segment", -1, "Last segment to download, inclusive (default: the last one)")
+	startTime := flag.String("start-time", "", "Download from this playback time, e.g. 00:05:00")
+	endTime := flag.String("end-time", "", "Download up to this playback time, e.g. 00:10:00")
+	skipDiscontinuities := flag.Bool("skip-discontinuities", false, "Leave out ad breaks bracketed by discontinuities")
+	var headerFlags stringList
+	flag.Var(&headerFlags, "header", "Extra request header \"Key: Value\" (repeatable)")
+	userAgent := flag.String("user-agent", "", "User-Agent for every request; \"\" sends none (default: Mozilla/5.0)")
+	cookie := flag.String("cookie", "", "Cookie header value or path to a cookies.txt file")
+	user := flag.String("user", "", "HTTP Basic Auth user name")
+	password := flag.String("password", "", "HTTP Basic Auth password")
+	limit := flag.String("limit", "", "Cap total download speed, e.g. 500KB/s or 5MB/s")
+	maxSegmentSize := flag.String("max-segment-size", "", "Reject segments larger than this, e.g. 1GB; 0 disables (default: 512MB)")
+	retries := flag.Int("retries", m3u8dl.DefaultRetries, "Retries per failed segment, playlist or key")
+	failover := flag.Bool("failover", false, "Retry failed segments from redundant variants on other hosts")
+	refreshOn403 := flag.Int("refresh-on-403", 0, "Re-fetch the playlist for fresh segment URLs after this many 403s (0 disables)")
+	timeout := flag.Duration("timeout", m3u8dl.DefaultTimeout, "Per-request timeout, e.g. 30s or 2m")
+	maxDuration := flag.Duration("max-duration", 0, "Give up if downloading segments takes longer than this, e.g. 2h")
+	backoff := flag.Duration("backoff", m3u8dl.DefaultBackoff, "Base delay between retries, grows with each attempt")
+	maxTotalRetries := flag.Int("max-total-retries", 0, "Abort once this many retries were made across all segments (0 means no limit)")
+	maxBackoff := flag.Duration("max-backoff", m3u8dl.DefaultMaxBackoff, "Longest single delay between retries")
+	retryBudget := flag.Duration("retry-budget", 0, "Give up on a segment after retrying it for this long (0 means no limit)")
+	maxErrors := flag.Int("max-errors", m3u8dl.DefaultMaxErrors, "Abort after this many failed segments (0 never aborts)")
+	continueOnError := flag.Bool("continue-on-error", false, "Merge what downloaded, leaving gaps for failed segments")
+	noTSCheck := flag.Bool("no-ts-check", false, "Don't verify decrypted segments are MPEG-TS")
+	lowMem := flag.Bool("low-mem", false, "Stream segments to disk instead of buffering them in memory")
+	verify := flag.String("verify", "", "sha256sum file with expected digests of the segments")
+	mp4 := flag.Bool("mp4", false, "Convert the result to MP4 with ffmpeg")
+	audio := flag.String("audio", "", "Language of the separate audio track to download (e.g. en)")
+	subs := flag.String("subs", "", "Save WebVTT subtitles in this language next to the output")
+	tmpDir := flag.String("tmpdir", "", "Directory for temporary segment files (default: the OS temp dir)")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification")
+	var caCerts stringList
+	flag.Var(&caCerts, "cacert", "PEM file of CA certificates to trust besides the system ones (repeatable)")
+	connsPerHost := flag.Int("conns-per-host", 0, "Connections per host (default: one per worker)")
+	dns := flag.String("dns", "", "DNS server to resolve hosts with, e.g. 1.1.1.1:53")
+	preferIPv4 := flag.Bool("prefer-ipv4", false, "Connect over IPv4 first, falling back to IPv6")
+	proxy := flag.String("proxy", "", "Proxy URL for all requests (default: HTTP_PROXY / HTTPS_PROXY)")
+	noProxy := flag.String("no-proxy", "", "Comma-separated hosts that bypass the proxy (default: NO_PROXY)")
+	testDecrypt := flag.Bool("test-decrypt", false, "Decrypt only the first encrypted segment and check the result")
+	dryRun := flag.Bool("dry-run", false, "Parse the playlist and print a summary without downloading")
+	keepTS := flag.Bool("keep-ts", false, "Keep the merged .ts after converting to MP4")
+	keepSegments := flag.Bool("keep-segments", false, "Keep segments and a local.m3u8 instead of merging")
+	concatList := flag.Bool("concat-list", false, "Keep segments and write an ffmpeg concat.txt instead of merging")
+	jsonOutput := flag.Bool("json", false, "Print newline-delimited JSON events instead of text")
+	quiet := flag.Bool("quiet", false, "Only print errors and the final output path")
+	verbose := flag.Bool("verbose", false, "Also print the URL of every segment downloaded")
+	stats := flag.Bool("stats", false, "Print download statistics at the end")
+	metadata := flag.Bool("metadata", false, "Write the source URL, variant, duration and encryption to <output>.json")
+	onComplete := flag.String("on-complete", "", "Shell command to run after a successful download, given the output as $M3U8_OUTPUT")
+	logLevel := flag.String("log-level", "", "Log diagnostics at this level to stderr: debug, info, warn or error")
+	trace := flag.Bool("trace", false, "Print every HTTP request and response to stderr")
+	help := flag.Bool("help", false, "Show help")
+
+	flag.Parse()
+
+	if *help || (*m3u8URL == "" && *batch == "") {
+		fmt.Println(`
+╔════════════════════════════════════════════════════════╗
+║         🎬 High-Speed M3U8 Video Downloader           ║
+║                    (Golang Version)                   ║
+╚════════════════════════════════════════════════════════╝
+
+Usage: m3u8_downloader -url <m3u8_url> [options]
+
+Options:
+  -url string
+        M3U8 playlist URL (required unless -batch is given); also a local path, file:// URL or data: URI
+  -batch string
+        Download every playlist listed in this file, one "url [output]" per line (# starts a comment),
+        carrying on past failures and summarizing at the end
+  -base-url string
+        Resolve relative segment and key URIs against this URL instead of the playlist's location
+        (also the base for local and data: playlists)
+  -output string
+        Output file path (default: output.ts); "-" writes the video to stdout for piping, with
+        all messages on stderr
+  -force
+        Overwrite an existing output file. Without it an existing -output stops the download,
+        and the default name moves to output_1.ts, output_2.ts, ...
+  -workers int
+        Number of concurrent downloads (default: 32)
+  -stream
+        Append segments to the output in order as they finish (no temp files)
+  -stream-buffer int
+        Segments -stream may hold in memory waiting to be written (default: 2 x workers). When the
+        disk falls behind, new downloads pause; lower it to save memory, raise it if one slow segment
+        stalls the others
+  -pipeline-merge
+        Merge while downloading by writing each segment at its offset in a preallocated output
+        (needs sizes from HEAD or byte ranges, unencrypted only; otherwise merges afterwards)
+  -resume
+        Keep segments on failure and skip them when re-run with the same URL
+  -merge-on-interrupt
+        When Ctrl-C stops the download, merge the segments finished so far (up to the first
+        missing one) into the output instead of discarding them; handy for stopping a -live capture
+  -keep-temp-on-error
+        Keep the temp directory when a download fails and print its path, to inspect the raw
+        segments that were fetched
+  -keep-temp
+        Keep the temp directory and every segment in it even after a successful merge
+  -live
+        Record a live stream, reloading the playlist until EXT-X-ENDLIST
+  -seq-names
+        Name temp segment files by their EXT-X-MEDIA-SEQUENCE number, not their position
+  -dedupe
+        Fetch each repeated segment URL and byte range once, still writing it at every position
+  -resolution string
+        Pick the best variant no larger than this, e.g. 1280x720 or 720p
+  -max-bandwidth int
+        Pick the best variant at or below this many bits/s
+  -list-variants
+        Print the variants of a master playlist without downloading
+  -start-segment int
+        Download from this segment on, counting from 0
+  -end-segment int
+        Stop after this segment (inclusive); with -start-segment grabs a slice of the video
+  -start-time string
+        Download from this position, e.g. 00:05:00, 5:00 or 300 (whole segments are kept)
+  -end-time string
+        Download up to this position; -start-time 00:05:00 -end-time 00:10:00 grabs a ~5 min clip
+  -skip-discontinuities
+        Leave out ad breaks spliced in between two EXT-X-DISCONTINUITY tags. Assumes the
+        stream starts with the programme and each break has exactly one discontinuity at
+        either end; check the result, as ads with their own discontinuities drop the programme
+  -header "Key: Value"
+        Send an extra HTTP header with every request (repeatable)
+  -user-agent string
+        User-Agent for playlist, key and segment requests, e.g. "AppleCoreMedia/1.0.0" or an
+        ExoPlayer string for origins that check the player; -user-agent "" sends no User-Agent
+        (default: Mozilla/5.0)
+  -cookie string
+        Raw "name=value; ..." cookies or a Netscape cookies.txt file
+  -user string
+        HTTP Basic Auth user name for playlist, key and segment requests
+        (user:password@ in -url works too and is kept out of messages)
+  -password string
+        HTTP Basic Auth password
+  -limit string
+        Cap the combined download speed of all workers (KB/s, MB/s, GB/s)
+  -max-segment-size string
+        Fail segments larger than this (KB, MB, GB; default: 512MB), checked against Content-Length
+        before reading; 0 disables the limit
+  -retries int
+        Retries per failed segment (default: 3). Playlist and key requests get as many, with the
+        same backoff, when they fail with a network error, a 5xx, 408 or 429
+  -refresh-on-403 int
+        Re-fetch the playlist for freshly signed segment URLs after this many 403 responses
+  -failover
+        When a segment fails on every retry, fetch it from a redundant variant of the master
+        playlist (same bandwidth, resolution and codecs on another URL)
+  -timeout duration
+        Per-request timeout, e.g. 30s or 2m (default: 30s)
+  -max-duration duration
+        Abort if downloading the segments takes longer than this in total, e.g. 2h
+  -backoff duration
+        Base delay between retries, multiplied by the attempt number
+        with ±50% random jitter (default: 1s)
+  -max-total-retries int
+        Abort the download once all segments together have used this many retries,
+        bounding the time spent on a failing origin (default: no limit)
+  -max-backoff duration
+        Cap on any single delay between retries (default: 30s)
+  -retry-budget duration
+        Fail a segment once its attempts have taken this long, e.g. 2m (default: no limit)
+  -max-errors int
+        Abort once more than this many segments fail, 0 never aborts (default: 10)
+  -continue-on-error
+        Skip failed segments and merge the rest instead of failing
+  -no-ts-check
+        Accept decrypted segments that don't look like MPEG-TS (non-standard containers)
+  -verify file
+        Check each segment against a sha256sum file keyed by segment URL, file name or index
+  -mp4
+        Convert to MP4 with ffmpeg after merging (implied by an -output ending in .mp4)
+  -keep-ts
+        Keep the merged .ts file after converting to MP4
+  -keep-segments
+        Don't merge; move the segments into a folder named after -output with a local.m3u8
+  -concat-list
+        Like -keep-segments, plus a concat.txt for ffmpeg's concat demuxer, which corrects
+        timestamps per segment; prints the ffmpeg command to mux them
+  -audio string
+        Audio language for streams with separate audio tracks, e.g. en (default: the stream's default)
+  -subs string
+        Download subtitles in this language into a .vtt file next to the output
+  -low-mem
+        Write segments to disk as they arrive (decrypting on the fly) instead of buffering each one
+  -tmpdir string
+        Where to keep segments until the merge (default: the OS temp dir)
+  -conns-per-host int
+        Max connections (and idle keep-alive connections) per host (default: same as -workers)
+  -dns string
+        Resolve hosts with this DNS server instead of the system resolver, e.g. 1.1.1.1 or 1.1.1.1:53
+  -prefer-ipv4
+        Try IPv4 addresses first and fall back to IPv6, for networks with broken IPv6
+  -proxy string
+        Send every request through this proxy, e.g. http://proxy:3128 or socks5://127.0.0.1:1080
+        (default: HTTP_PROXY for http:// and HTTPS_PROXY for https:// URLs)
+  -no-proxy string
+        Comma-separated hosts, domains (.corp.example) and CIDR ranges reached directly, replacing
+        NO_PROXY; localhost is never proxied
+  -insecure
+        Accept self-signed or invalid TLS certificates (unsafe)
+  -cacert file
+        Also trust the CA certificates in this PEM file, for private CAs and corporate proxies
+        (repeatable); a safe alternative to -insecure
+  -test-decrypt
+        Fetch the key and only the first encrypted segment, decrypt it and report the method, key
+        length and IV used and whether the result looks like TS or fMP4, then exit
+  -dry-run
+        Resolve and parse the playlist, print segments, duration and encryption, then exit
+  -json
+        Emit progress and status as newline-delimited JSON events
+  -quiet
+        Suppress banners and progress, print only errors and the output path
+  -verbose
+        Log each segment URL as it is downloaded
+  -stats
+        Print statistics at the end: bytes, average and peak speed, retries and the slowest segment
+  -metadata
+        Write <output>.json next to the video with the source URL, chosen variant (bandwidth,
+        resolution, codecs), duration, segment count, encryption and start time
+  -on-complete string
+        Shell command to run after each successful download, e.g. 'mv "$M3U8_OUTPUT" /archive/'.
+        The output path is in $M3U8_OUTPUT (and $1), the playlist URL in $M3U8_URL; a failing
+        command makes the run exit with status 7
+  -log-level string
+        Write diagnostics (requests, HTTP statuses, retries, key fetches, redirects) to stderr
+        at or above debug, info, warn or error; JSON lines with -json (default: off)
+  -trace
+        Print every HTTP request (method, URL, headers) and response (status, Content-Length,
+        Content-Type, time taken) to stderr, with Authorization and Cookie values redacted
+  -help
+        Show this help message
+
+Exit status:
+  0 success, 1 setup failed, 2 invalid flags, 3 playlist failed, 4 download failed,
+  5 merge or remux failed, 6 some -batch downloads failed,
+  7 -on-complete command failed, 130 interrupted with Ctrl-C
+
+Examples:
+  m3u8_downloader -url "https://example.com/video.m3u8"
+  m3u8_downloader -url "https://example.com/video.m3u8" -output "video.ts" -workers 64
+
+Why faster than ffmpeg?
+  ✓ Concurrent segment downloads (default: 32 workers)
+  ✓ Efficient memory management
+  ✓ Smart retry logic with exponential backoff
+  ✓ Direct TS merging (no re-encoding)
+  ✓ Optimized for high-bandwidth scenarios
+
+Tips for maximum speed:
+  1. Increase workers: -workers 64 (use higher on fast connections)
+  2. Convert TS to MP4 after (optional): ffmpeg -i output.ts -c copy output.mp4
+  3. Check your internet bandwidth: speedtest.net
+
+		`)
+		if !*help {
+			return &exitError{code: exitUsage, message: "-url is required"}
+		}
+		return nil
+	}
+
+	// With -output - the video goes to stdout, so everything else moves aside
+	console := os.Stdout
+	if *outputFile == m3u8dl.StdoutOutput {
+		console = os.Stderr
+	}
+	out := m3u8dl.NewTextWriter(console)
+	if *jsonOutput {
+		out = m3u8dl.NewJSONWriter(console)
+	}
+	if *quiet {
+		out = m3u8dl.Quiet(out)
+	}
+
+	// Diagnostics go to stderr, apart from the progress and status output
+	var logger *slog.Logger
+	if *logLevel != "" {
+		level, err := m3u8dl.ParseLogLevel(*logLevel)
+		if err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+		handlerOpts := &slog.HandlerOptions{Level: level}
+		if *jsonOutput {
+			logger = slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts))
+		} else {
+			logger = slog.New(slog.NewTextHandler(os.Stderr, handlerOpts))
+		}
+	}
+
+	// Adjust workers
+	if *workers <= 0 {
+		report(out, "warning", map[string]interface{}{"message": "invalid worker count, using default"},
+			"⚠️  Invalid worker count %d, using default %d\n", *workers, m3u8dl.DefaultWorkers)
+		*workers = m3u8dl.DefaultWorkers
+	} else if *workers != m3u8dl.DefaultWorkers {
+		report(out, "workers", map[string]interface{}{"workers": *workers}, "⚙️  Using %d concurrent workers\n", *workers)
+	}
+
+	maxWidth, maxHeight := 0, 0
+	if *resolution != "" {
+		var err error
+		maxWidth, maxHeight, err = m3u8dl.ParseResolution(*resolution)
+		if err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+	}
+
+	headers := http.Header{}
+	for _, h := range headerFlags {
+		key, value, err := m3u8dl.ParseHeader(h)
+		if err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+		headers.Add(key, value)
+	}
+
+	if *retries < 0 {
+		return reportError(out, exitUsage, "-retries must not be negative")
+	}
+	if *timeout <= 0 {
+		return reportError(out, exitUsage, "-timeout must be positive")
+	}
+	if *backoff <= 0 {
+		return reportError(out, exitUsage, "-backoff must be positive")
+	}
+	if *streamBuffer < 0 {
+		return reportError(out, exitUsage, "-stream-buffer must not be negative")
+	}
+	if *maxTotalRetries < 0 {
+		return reportError(out, exitUsage, "-max-total-retries must not be negative")
+	}
+	if *maxBackoff <= 0 {
+		return reportError(out, exitUsage, "-max-backoff must be positive")
+	}
+	if *retryBudget < 0 {
+		return reportError(out, exitUsage, "-retry-budget must not be negative")
+	}
+	if *maxErrors < 0 {
+		return reportError(out, exitUsage, "-max-errors must not be negative")
+	}
+	// The library treats 0 as "use the default", negative as "no retries"
+	retryCount := *retries
+	if retryCount == 0 {
+		retryCount = -1
+	}
+	errorLimit := *maxErrors
+	if errorLimit == 0 {
+		errorLimit = -1
+	}
+
+	if *startSegment < 0 || (isFlagSet("end-segment") && *endSegment < 0) {
+		return reportError(out, exitUsage, "-start-segment and -end-segment can't be negative")
+	}
+	// The library takes an exclusive end where 0 means "through the last one"
+	rangeEnd := 0
+	if isFlagSet("end-segment") {
+		if *endSegment < *startSegment {
+			return reportError(out, exitUsage, "-end-segment %d is before -start-segment %d", *endSegment, *startSegment)
+		}
+		rangeEnd = *endSegment + 1
+	}
+
+	var clipStart, clipEnd time.Duration
+	for _, t := range []struct {
+		value string
+		dest  *time.Duration
+	}{{*startTime, &clipStart}, {*endTime, &clipEnd}} {
+		if t.value == "" {
+			continue
+		}
+		var err error
+		if *t.dest, err = m3u8dl.ParseTimestamp(t.value); err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+	}
+	if clipEnd != 0 && clipEnd <= clipStart {
+		return reportError(out, exitUsage, "-end-time %s is not after -start-time %s", clipEnd, clipStart)
+	}
+
+	var rateLimit int64
+	if *limit != "" {
+		var err error
+		rateLimit, err = m3u8dl.ParseRate(*limit)
+		if err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+	}
+
+	var segmentLimit int64
+	if *maxSegmentSize == "0" {
+		segmentLimit = -1
+	} else if *maxSegmentSize != "" {
+		var err error
+		segmentLimit, err = m3u8dl.ParseSize(*maxSegmentSize)
+		if err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+	}
+
+	if *dns != "" {
+		host, _, err := net.SplitHostPort(m3u8dl.DNSServer(*dns))
+		if err != nil || net.ParseIP(host) == nil {
+			return reportError(out, exitUsage, "-dns must be an IP address with an optional port, e.g. 1.1.1.1:53")
+		}
+	}
+
+	if *proxy != "" {
+		if _, err := m3u8dl.ParseProxy(*proxy); err != nil {
+			return reportError(out, exitUsage, "%v", err)
+		}
+	}
+	var noProxyHosts []string
+	if isFlagSet("no-proxy") {
+		noProxyHosts = m3u8dl.ParseNoProxy(*noProxy)
+	}
+
+	var checksums m3u8dl.Checksums
+	if *verify != "" {
+		var err error
+		checksums, err = m3u8dl.LoadChecksums(*verify)
+		if err != nil {
+			return reportError(out, exitFailure, "Error loading checksums: %v", err)
+		}
+	}
+
+	// Fail before downloading anything if the conversion can't run
+	if *mp4 {
+		if _, err := m3u8dl.FindFFmpeg(); err != nil {
+			return reportError(out, exitFailure, "%v", err)
+		}
+	}
+
+	var rootCAs *x509.CertPool
+	if len(caCerts) > 0 {
+		var err error
+		if rootCAs, err = m3u8dl.LoadCACerts(caCerts); err != nil {
+			return reportError(out, exitFailure, "Error loading CA certificates: %v", err)
+		}
+	}
+
+	if *insecure {
+		report(out, "warning", map[string]interface{}{"message": "TLS certificate verification disabled"},
+			"⚠️  -insecure: TLS certificates are not verified, the server's identity can't be trusted\n")
+	}
+
+	if *keepSegments && (*stream || *mp4) {
+		return reportError(out, exitUsage, "-keep-segments can't be combined with -stream or -mp4")
+	}
+	if *concatList && (*stream || *mp4) {
+		return reportError(out, exitUsage, "-concat-list can't be combined with -stream or -mp4")
+	}
+	if *outputFile == m3u8dl.StdoutOutput && (*mp4 || *keepSegments || *concatList || *audio != "" || *subs != "" || *metadata) {
+		return reportError(out, exitUsage, "-output - can't be combined with -mp4, -keep-segments, -concat-list, -audio, -subs or -metadata")
+	}
+
+	var entries []batchEntry
+	if *batch != "" {
+		if *m3u8URL != "" || isFlagSet("output") {
+			return reportError(out, exitUsage, "-batch can't be combined with -url or -output, list them in the batch file")
+		}
+		var err error
+		if entries, err = readBatch(*batch); err != nil {
+			return reportError(out, exitUsage, "Error reading batch file: %v", err)
+		}
+	}
+
+	if *resume && *stream {
+		report(out, "warning", map[string]interface{}{"message": "-resume has no effect with -stream"},
+			"⚠️  -resume has no effect with -stream, segments are not kept on disk\n")
+	}
+
+	cli := &job{
+		tempParent:   *tmpDir,
+		cookieFile:   *cookie,
+		resume:       *resume,
+		keepTemp:     *keepTemp,
+		keepTempErr:  *keepTempOnError,
+		partialMerge: *mergeOnInterrupt,
+		dryRun:       *dryRun,
+		testDecrypt:  *testDecrypt,
+		listVariants: *listVariants,
+		quiet:        *quiet,
+		concatList:   *concatList,
+		stats:        *stats,
+		metadata:     *metadata,
+		onComplete:   *onComplete,
+	}
+	if cli.tempParent == "" {
+		cli.tempParent = os.TempDir()
+	}
+
+	// Options shared by every download; an unset -output lets the library
+	// pick the extension
+	opts := m3u8dl.Options{
+		URL:        *m3u8URL,
+		BaseURL:    *baseURL,
+		OutputFile: *outputFile,
+		Workers:    *workers,
+		Retries:    retryCount,
+		MaxErrors:  errorLimit,
+		Timeout:    *timeout,
+		Backoff:    *backoff,
+		Stream:     *stream,
+		Resume:     *resume,
+		KeepTemp:   *keepTemp,
+		Live:       *live,
+
+		StreamBuffer: *streamBuffer,
+		MaxDuration:  *maxDuration,
+		MaxBackoff:   *maxBackoff,
+		RetryBudget:  *retryBudget,
+		RefreshOn403: *refreshOn403,
+		Failover:     *failover,
+
+		SequenceNames: *seqNames,
+		PipelineMerge: *pipelineMerge,
+		Dedupe:        *dedupe,
+
+		ContinueOnError: *continueOnError,
+		MaxTotalRetries: *maxTotalRetries,
+		MaxSegmentSize:  segmentLimit,
+		SkipTSCheck:     *noTSCheck,
+		LowMemory:       *lowMem,
+
+		Remux:  *mp4,
+		KeepTS: *keepTS,
+		// A decryption test writes nothing, so an existing output doesn't matter
+		Force: *force || *testDecrypt,
+
+		KeepSegments: *keepSegments,
+		ConcatList:   *concatList,
+
+		MaxWidth:     maxWidth,
+		MaxHeight:    maxHeight,
+		MaxBandwidth: *maxBandwidth,
+		StartSegment: *startSegment,
+		EndSegment:   rangeEnd,
+		StartTime:    clipStart,
+		EndTime:      clipEnd,
+		ListVariants: *listVariants,
+		DryRun:       *dryRun,
+		Audio:        *audio,
+		Subtitles:    *subs,
+		Headers:      headers,
+		UserAgent:    *userAgent,
+		NoUserAgent:  isFlagSet("user-agent") && *userAgent == "",
+		Username:     *user,
+		Password:     *password,
+		Checksums:    checksums,
+		RateLimit:    rateLimit,
+		Insecure:     *insecure,
+		RootCAs:      rootCAs,
+		ConnsPerHost: *connsPerHost,
+		DNS:          *dns,
+		PreferIPv4:   *preferIPv4,
+		Proxy:        *proxy,
+		NoProxy:      noProxyHosts,
+		Events:       out,
+		Verbose:      *verbose,
+		Logger:       logger,
+	}
+	if *trace {
+		opts.Trace = os.Stderr
+	}
+	if !isFlagSet("output") {
+		opts.OutputFile = ""
+	}
+	if *skipDiscontinuities {
+		opts.Filter = m3u8dl.SkipAdBreaks
+	}
+
+	// Ctrl-C cancels in-flight requests and still runs cleanup
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if *batch != "" {
+		return cli.downloadBatch(ctx, out, opts, entries)
+	}
+	return cli.download(ctx, out, opts)
+}
+
+// Settings of a run that aren't download Options
+type job struct {
+	tempParent   string // Where each download's temp directory is created
+	cookieFile   string
+	resume       bool
+	keepTemp     bool // Never remove the temp directory
+	keepTempErr  bool // Keep the temp directory when the download fails
+	partialMerge bool // Merge the finished segments when Ctrl-C stops the download
+	dryRun       bool
+	testDecrypt  bool
+	listVariants bool
+	quiet        bool
+	concatList   bool
+	stats        bool
+	metadata     bool   // Write a JSON sidecar next to each output
+	onComplete   string // Shell command run after each successful download
+}
+
+// Download opts.URL into opts.OutputFile, reporting progress and the result
+func (j *job) download(ctx context.Context, out m3u8dl.EventWriter, opts m3u8dl.Options) error {
+	jar, _ := cookiejar.New(nil)
+	if j.cookieFile != "" {
+		if err := m3u8dl.LoadCookies(jar, j.cookieFile, opts.URL); err != nil {
+			return reportError(out, exitFailure, "Error loading cookies: %v", err)
+		}
+	}
+	opts.Jar = jar
+
+	// Create temp directory, named after the URL so a re-run can find it;
+	// nothing is written to disk when only inspecting the playlist
+	tempDir := ""
+	if !j.dryRun && !j.listVariants && !j.testDecrypt {
+		var err error
+		tempDir, err = makeTempDir(j.tempParent, opts.URL, j.resume)
+		if err != nil {
+			return reportError(out, exitFailure, "Error creating temp directory: %v", err)
+		}
+	}
+	opts.TempDir = tempDir
+	downloader := m3u8dl.NewDownloader(opts)
+
+	// With -resume, segments survive a failed run for the next attempt;
+	// -keep-temp and -keep-temp-on-error leave them for inspection
+	succeeded := false
+	defer func() {
+		switch {
+		case tempDir != "" && (j.keepTemp || (!succeeded && j.keepTempErr)):
+			report(out, "temp_kept", map[string]interface{}{"dir": tempDir}, "🗂️  Temp files kept in %s\n", tempDir)
+		case !succeeded && j.resume:
+			report(out, "partial_kept", map[string]interface{}{"dir": tempDir},
+				"💾 Partial download kept in %s, re-run with -resume to continue\n", tempDir)
+		default:
+			downloader.Cleanup()
+		}
+	}()
+
+	// Parse M3U8
+	if err := downloader.ParseM3U8(ctx); err != nil {
+		return reportError(out, stageCode(ctx, exitParse), "Error parsing M3U8: %v", err)
+	}
+
+	if j.listVariants {
+		printVariants(out, downloader.Variants(), downloader.IFrameVariants())
+		return nil
+	}
+
+	if j.dryRun {
+		printSummary(out, downloader.Summary())
+		return nil
+	}
+
+	if j.testDecrypt {
+		test, err := downloader.TestDecrypt(ctx)
+		if err != nil {
+			return reportError(out, stageCode(ctx, exitDownload), "Decryption test failed: %v", err)
+		}
+		printDecryptTest(out, test)
+		if !test.OK() {
+			return &exitError{code: exitDownload, message: "decryption test failed"}
+		}
+		return nil
+	}
+
+	// Statistics cover the segment download, so print them even if it fails
+	if j.stats {
+		defer func() { printStats(out, downloader.Stats()) }()
+	}
+
+	// Download segments
+	if err := downloader.DownloadSegments(ctx); err != nil {
+		err = reportError(out, stageCode(ctx, exitDownload), "Error downloading segments: %v", err)
+		// Keep what a manually stopped capture got so far
+		if j.partialMerge && ctx.Err() != nil {
+			if _, mergeErr := downloader.MergeCompleted(); mergeErr != nil {
+				report(out, "warning", map[string]interface{}{"message": mergeErr.Error()},
+					"⚠️  Failed to save the finished segments: %v\n", mergeErr)
+			}
+		}
+		return err
+	}
+
+	// Merge segments
+	if err := downloader.MergeSegments(); err != nil {
+		return reportError(out, exitMerge, "Error merging segments: %v", err)
+	}
+
+	if err := downloader.DownloadAudio(ctx); err != nil {
+		return reportError(out, stageCode(ctx, exitDownload), "Error downloading audio: %v", err)
+	}
+
+	// The video is done, missing subtitles shouldn't fail the run
+	if err := downloader.DownloadSubtitles(ctx); err != nil {
+		report(out, "warning", map[string]interface{}{"message": err.Error()}, "⚠️  Failed to download subtitles: %v\n", err)
+	}
+
+	if err := downloader.Remux(ctx); err != nil {
+		return reportError(out, stageCode(ctx, exitMerge), "Error remuxing with ffmpeg: %v", err)
+	}
+
+	// A missing sidecar leaves the video itself usable
+	if j.metadata {
+		if _, err := downloader.WriteMetadata(); err != nil {
+			report(out, "warning", map[string]interface{}{"message": err.Error()}, "⚠️  Failed to write metadata: %v\n", err)
+		}
+	}
+
+	succeeded = true
+	output := downloader.OutputFile()
+	j.reportComplete(out, output, downloader.ConcatCommand())
+	if j.onComplete != "" {
+		return runHook(ctx, out, j.onComplete, output, opts.URL)
+	}
+	return nil
+}
+
+// Print where the finished download went and what to do with it next
+func (j *job) reportComplete(out m3u8dl.EventWriter, output, concatCommand string) {
+	if j.quiet {
+		report(out, "complete", map[string]interface{}{"output": output}, "%s\n", output)
+		return
+	}
+	if output == m3u8dl.StdoutOutput {
+		report(out, "complete", map[string]interface{}{"output": output}, "\n🎉 Download complete, video written to stdout\n")
+		return
+	}
+	if j.concatList && filepath.Base(output) == m3u8dl.ConcatListName {
+		report(out, "complete", map[string]interface{}{"output": output, "command": concatCommand},
+			"\n🎉 Download complete!\n📁 Concat list: %s\n\n💡 Mux it with ffmpeg:\n   %s\n", output, concatCommand)
+		return
+	}
+	quoted := m3u8dl.ShellQuote(output)
+	if strings.EqualFold(filepath.Ext(output), ".mp4") {
+		report(out, "complete", map[string]interface{}{"output": output},
+			"\n🎉 Download complete!\n📁 Output: %s\n\n💡 Play it: ffplay %s\n", output, quoted)
+		return
+	}
+	report(out, "complete", map[string]interface{}{"output": output},
+		"\n🎉 Download complete!\n📁 Output: %s\n\n💡 Next steps:\n"+
+			"   Convert to MP4: ffmpeg -i %s -c copy %s\n"+
+			"   Or play directly: ffplay %s\n", output, quoted,
+		m3u8dl.ShellQuote(strings.TrimSuffix(output, filepath.Ext(output))+".mp4"), quoted)
+}